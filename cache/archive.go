@@ -0,0 +1,254 @@
+package cache
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveDir writes every directory, regular file and symlink under root into the tar writer
+func archiveDir(tarWriter *tar.Writer, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		} else if relPath == "." {
+			return nil
+		}
+
+		header := &tar.Header{
+			Name:    filepath.ToSlash(relPath),
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: info.ModTime().Truncate(time.Second),
+			Format:  tar.FormatPAX,
+		}
+
+		switch {
+		case info.IsDir():
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+		case info.Mode()&os.ModeSymlink != 0:
+			header.Typeflag = tar.TypeSymlink
+			if header.Linkname, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			header.Typeflag = tar.TypeReg
+			header.Size = info.Size()
+		default:
+			// sockets, devices and pipes are not build outputs
+			return nil
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(tarWriter, file)
+		return err
+	})
+}
+
+type extractedDir struct {
+	path    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// extractTar writes every entry of the tar stream under root, restoring directories,
+// regular files and symlinks with their permissions and modification times
+func extractTar(tarReader *tar.Reader, root string) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return err
+	}
+
+	// directory modes and times are applied last, since extracting files into them changes their mtime
+	dirs := []*extractedDir{}
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		absPath, err := safeJoin(root, header.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := ensureParentInside(root, absPath); err != nil {
+			return err
+		}
+
+		mode := header.FileInfo().Mode().Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(absPath, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, &extractedDir{path: absPath, mode: mode, modTime: header.ModTime})
+
+		case tar.TypeReg:
+			if err := extractFile(tarReader, absPath, mode, header.ModTime); err != nil {
+				return fmt.Errorf("extracting %s: %w", header.Name, err)
+			}
+
+		case tar.TypeSymlink:
+			if err := checkSymlink(root, absPath, header.Linkname); err != nil {
+				return err
+			}
+
+			if err := os.RemoveAll(absPath); err != nil {
+				return err
+			}
+
+			if err := os.Symlink(header.Linkname, absPath); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("%s has unsupported type %c", header.Name, header.Typeflag)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return err
+		}
+
+		if !dirs[i].modTime.IsZero() {
+			if err := os.Chtimes(dirs[i].path, dirs[i].modTime, dirs[i].modTime); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func extractFile(r io.Reader, path string, mode os.FileMode, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	// never write through an existing file or link
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// the umask applies on creation
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+
+	if !modTime.IsZero() {
+		return os.Chtimes(path, modTime, modTime)
+	}
+
+	return nil
+}
+
+// safeJoin joins name onto root, rejecting absolute names and names that escape root
+func safeJoin(root, name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("archive entry %q has an absolute path", name)
+	}
+
+	joined := filepath.Join(root, name)
+	if !isInside(root, joined) {
+		return "", fmt.Errorf("archive entry %q escapes the destination", name)
+	}
+
+	return joined, nil
+}
+
+// ensureParentInside rejects entries whose parent folder resolves outside root through a symlink
+func ensureParentInside(root, path string) error {
+	parent := filepath.Dir(path)
+	if parent == root {
+		return nil
+	}
+
+	// find the deepest existing ancestor, since the rest will be created as plain folders
+	existing := parent
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return err
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	if !isInside(resolvedRoot, resolved) {
+		return fmt.Errorf("archive entry %s is written through a link outside the destination", path)
+	}
+
+	return nil
+}
+
+// checkSymlink rejects links that point outside root
+func checkSymlink(root, path, linkname string) error {
+	if filepath.IsAbs(linkname) {
+		return fmt.Errorf("symlink %s points to the absolute path %s", path, linkname)
+	}
+
+	if !isInside(root, filepath.Join(filepath.Dir(path), linkname)) {
+		return fmt.Errorf("symlink %s points outside the destination", path)
+	}
+
+	return nil
+}
+
+func isInside(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
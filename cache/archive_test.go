@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"gotest.tools/v3/assert"
+)
+
+type mockEntry struct {
+	header  *tar.Header
+	content string
+}
+
+func mockArchive(t *testing.T, entries ...mockEntry) string {
+	path := filepath.Join(t.TempDir(), "archive.tar.zst")
+	f, err := os.Create(path)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	zw, err := zstd.NewWriter(f)
+	assert.NilError(t, err)
+	tw := tar.NewWriter(zw)
+
+	for _, e := range entries {
+		e.header.Size = int64(len(e.content))
+		assert.NilError(t, tw.WriteHeader(e.header))
+		_, err := tw.Write([]byte(e.content))
+		assert.NilError(t, err)
+	}
+
+	assert.NilError(t, tw.Close())
+	assert.NilError(t, zw.Close())
+
+	return path
+}
+
+func mockOutItem(t *testing.T) *CacheItem {
+	return &CacheItem{OutDest: filepath.Join(t.TempDir(), "out")}
+}
+
+func TestDecompressRestoresEntries(t *testing.T) {
+	mtime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	archive := mockArchive(t,
+		mockEntry{header: &tar.Header{Typeflag: tar.TypeDir, Name: "bin/", Mode: 0750, ModTime: mtime}},
+		mockEntry{header: &tar.Header{Typeflag: tar.TypeReg, Name: "bin/tool", Mode: 0755, ModTime: mtime}, content: "#!/bin/sh"},
+		mockEntry{header: &tar.Header{Typeflag: tar.TypeSymlink, Name: "tool", Linkname: "bin/tool"}},
+		mockEntry{header: &tar.Header{Typeflag: tar.TypeDir, Name: "empty/", Mode: 0700, ModTime: mtime}},
+	)
+
+	ci := mockOutItem(t)
+	assert.NilError(t, ci.Decompress(archive))
+
+	info, err := os.Stat(filepath.Join(ci.OutDest, "bin/tool"))
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0755))
+	assert.Assert(t, info.ModTime().Equal(mtime))
+
+	info, err = os.Stat(filepath.Join(ci.OutDest, "bin"))
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0750))
+	assert.Assert(t, info.ModTime().Equal(mtime))
+
+	link, err := os.Readlink(filepath.Join(ci.OutDest, "tool"))
+	assert.NilError(t, err)
+	assert.Equal(t, link, "bin/tool")
+
+	info, err = os.Stat(filepath.Join(ci.OutDest, "empty"))
+	assert.NilError(t, err)
+	assert.Assert(t, info.IsDir())
+}
+
+func TestDecompressRejectsMaliciousArchives(t *testing.T) {
+	outside := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		entries []mockEntry
+		err     string
+	}{
+		"traversal": {
+			entries: []mockEntry{{header: &tar.Header{Typeflag: tar.TypeReg, Name: "../../evil", Mode: 0644}, content: "x"}},
+			err:     "escapes the destination",
+		},
+		"absolute": {
+			entries: []mockEntry{{header: &tar.Header{Typeflag: tar.TypeReg, Name: filepath.Join(outside, "evil"), Mode: 0644}, content: "x"}},
+			err:     "absolute path",
+		},
+		"absolute symlink": {
+			entries: []mockEntry{{header: &tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: outside}}},
+			err:     "points to the absolute path",
+		},
+		"escaping symlink": {
+			entries: []mockEntry{{header: &tar.Header{Typeflag: tar.TypeSymlink, Name: "dir/link", Linkname: "../../.."}}},
+			err:     "points outside the destination",
+		},
+		"device": {
+			entries: []mockEntry{{header: &tar.Header{Typeflag: tar.TypeChar, Name: "dev", Mode: 0644}}},
+			err:     "unsupported type",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ci := mockOutItem(t)
+			assert.ErrorContains(t, ci.Decompress(mockArchive(t, tc.entries...)), tc.err)
+
+			entries, err := os.ReadDir(outside)
+			assert.NilError(t, err)
+			assert.Equal(t, len(entries), 0)
+		})
+	}
+}
+
+func TestDecompressRejectsWritesThroughLinks(t *testing.T) {
+	outside := t.TempDir()
+	ci := mockOutItem(t)
+
+	// a link planted in the destination by a previous extraction
+	assert.NilError(t, os.MkdirAll(ci.OutDest, os.ModePerm))
+	assert.NilError(t, os.Symlink(outside, filepath.Join(ci.OutDest, "link")))
+
+	archive := mockArchive(t, mockEntry{header: &tar.Header{Typeflag: tar.TypeReg, Name: "link/evil", Mode: 0644}, content: "x"})
+	assert.ErrorContains(t, ci.Decompress(archive), "through a link outside the destination")
+
+	_, err := os.Stat(filepath.Join(outside, "evil"))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	src := mockOutItem(t)
+	mtime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.NilError(t, os.MkdirAll(filepath.Join(src.OutDest, "node_modules/.bin"), 0755))
+	assert.NilError(t, os.MkdirAll(filepath.Join(src.OutDest, "empty"), 0700))
+	assert.NilError(t, os.WriteFile(filepath.Join(src.OutDest, "node_modules/tool.js"), []byte("run()"), 0755))
+	assert.NilError(t, os.Symlink("../tool.js", filepath.Join(src.OutDest, "node_modules/.bin/tool")))
+	assert.NilError(t, os.Chtimes(filepath.Join(src.OutDest, "node_modules/tool.js"), mtime, mtime))
+
+	first := filepath.Join(t.TempDir(), "first.tar.zst")
+	second := filepath.Join(t.TempDir(), "second.tar.zst")
+	assert.NilError(t, src.Compress(first))
+	assert.NilError(t, src.Compress(second))
+
+	firstBytes, err := os.ReadFile(first)
+	assert.NilError(t, err)
+	secondBytes, err := os.ReadFile(second)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, firstBytes, secondBytes)
+
+	dest := mockOutItem(t)
+	assert.NilError(t, dest.Decompress(first))
+
+	link, err := os.Readlink(filepath.Join(dest.OutDest, "node_modules/.bin/tool"))
+	assert.NilError(t, err)
+	assert.Equal(t, link, "../tool.js")
+
+	info, err := os.Stat(filepath.Join(dest.OutDest, "node_modules/tool.js"))
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0755))
+	assert.Assert(t, info.ModTime().Equal(mtime))
+
+	info, err = os.Stat(filepath.Join(dest.OutDest, "empty"))
+	assert.NilError(t, err)
+	assert.Assert(t, info.IsDir())
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0700))
+}
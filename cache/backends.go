@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BackendFactory creates a CacheIO from the `config` map of a project cache block.
+// It should return an error when the configuration is not valid for the backend.
+type BackendFactory func(cfg map[string]string) (CacheIO, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]BackendFactory{}
+)
+
+func init() {
+	RegisterBackend("local", func(cfg map[string]string) (CacheIO, error) {
+		lc := NewLocalCache()
+		if err := lc.Load(cfg); err != nil {
+			return nil, err
+		}
+		return lc, nil
+	})
+
+	RegisterBackend("cas", func(cfg map[string]string) (CacheIO, error) {
+		cc := NewCasCache()
+		if err := cc.Load(cfg); err != nil {
+			return nil, err
+		}
+		return cc, nil
+	})
+
+	RegisterBackend("http", func(cfg map[string]string) (CacheIO, error) {
+		hc := NewHttpCache()
+		if err := hc.Load(cfg); err != nil {
+			return nil, err
+		}
+		return hc, nil
+	})
+}
+
+// RegisterBackend makes a cache backend available as a `type` in the project cache block.
+// It is meant to be called from init functions, and panics if the name is already taken.
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if name == "" || factory == nil {
+		panic("cache: backend needs a name and a factory")
+	}
+
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("cache: backend %s registered twice", name))
+	}
+
+	backends[name] = factory
+}
+
+// KnownBackends returns the sorted names of all registered backends
+func KnownBackends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func newBackend(name string, cfg map[string]string) (CacheIO, error) {
+	backendsMu.RLock()
+	factory, ok := backends[name]
+	backendsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown cache type %s. known types are: %s", name, strings.Join(KnownBackends(), ", "))
+	}
+
+	io, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s cache: %w", name, err)
+	}
+
+	return io, nil
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zen-io/zen-core/utils"
+	"gotest.tools/v3/assert"
+)
+
+type nopCache struct {
+	LocalCache
+	cfg map[string]string
+}
+
+func TestRegisterBackend(t *testing.T) {
+	RegisterBackend("test-nop", func(cfg map[string]string) (CacheIO, error) {
+		if cfg["fail"] != "" {
+			return nil, errors.New("invalid config")
+		}
+		return &nopCache{cfg: cfg}, nil
+	})
+	t.Cleanup(func() {
+		backendsMu.Lock()
+		delete(backends, "test-nop")
+		backendsMu.Unlock()
+	})
+
+	func() {
+		defer func() { assert.Assert(t, recover() != nil) }()
+		RegisterBackend("test-nop", nil)
+	}()
+
+	cm, err := NewCacheManager(&CacheConfig{
+		Artifacts: utils.StringPtr("/artifacts"),
+		Type:      utils.StringPtr("test-nop"),
+		Config:    map[string]string{"key": "value"},
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cm.io.(*nopCache).cfg, map[string]string{"artifacts": "/artifacts", "key": "value"})
+
+	_, err = NewCacheManager(&CacheConfig{
+		Type:   utils.StringPtr("test-nop"),
+		Config: map[string]string{"fail": "true"},
+	})
+	assert.ErrorContains(t, err, "loading test-nop cache: invalid config")
+
+	_, err = NewCacheManager(&CacheConfig{Type: utils.StringPtr("nfs")})
+	assert.ErrorContains(t, err, "unknown cache type nfs")
+}
+
+func TestNewCacheManagerDefaultsToLocal(t *testing.T) {
+	cm, err := NewCacheManager(&CacheConfig{Artifacts: utils.StringPtr("/artifacts")})
+	assert.NilError(t, err)
+
+	lc, ok := cm.io.(*LocalCache)
+	assert.Assert(t, ok)
+	assert.Equal(t, lc.root, "/artifacts")
+}
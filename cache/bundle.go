@@ -0,0 +1,374 @@
+package cache
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BundleVersion is bumped whenever the bundle layout changes
+const BundleVersion = 1
+
+const bundleManifestName = "manifest.json"
+
+// BundleEntry is a cache entry in a bundle. The archive is empty for targets without outs.
+type BundleEntry struct {
+	Project        string `json:"project"`
+	Key            string `json:"key"`
+	MetadataDigest string `json:"metadata_digest"`
+	ArchiveDigest  string `json:"archive_digest,omitempty"`
+}
+
+func (be *BundleEntry) metadataName() string {
+	return filepath.Join(be.Project, be.Key+".json")
+}
+
+func (be *BundleEntry) archiveName() string {
+	return filepath.Join(be.Project, be.Key+".tar.zst")
+}
+
+type BundleManifest struct {
+	Version int            `json:"version"`
+	Entries []*BundleEntry `json:"entries"`
+}
+
+// BundleWriter writes cache entries into a single portable file: a tar with the metadata and
+// archive of every entry, followed by a manifest with their digests. The bundle is written to a
+// temporary file next to path, which only takes its place once Close succeeds.
+type BundleWriter struct {
+	path     string
+	f        *os.File
+	tw       *tar.Writer
+	manifest *BundleManifest
+	added    map[string]bool
+}
+
+func NewBundleWriter(path string) (*BundleWriter, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	// temporary files are private, bundles are meant to be shared
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return &BundleWriter{
+		path:     path,
+		f:        f,
+		tw:       tar.NewWriter(f),
+		manifest: &BundleManifest{Version: BundleVersion, Entries: []*BundleEntry{}},
+		added:    map[string]bool{},
+	}, nil
+}
+
+func (bw *BundleWriter) addFile(name, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if err := bw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(name),
+		Mode:     0644,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().Truncate(1e9),
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(bw.tw, h), f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Close writes the manifest and moves the bundle into place. On error, the bundle is discarded.
+func (bw *BundleWriter) Close() error {
+	if err := bw.finish(); err != nil {
+		bw.Abort()
+		return err
+	}
+
+	if err := os.Rename(bw.f.Name(), bw.path); err != nil {
+		os.Remove(bw.f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// Abort discards the bundle, leaving nothing behind
+func (bw *BundleWriter) Abort() {
+	bw.f.Close()
+	os.Remove(bw.f.Name())
+}
+
+func (bw *BundleWriter) finish() error {
+	defer bw.f.Close()
+
+	data, err := json.MarshalIndent(bw.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := bw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     bundleManifestName,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return err
+	}
+
+	if _, err := bw.tw.Write(data); err != nil {
+		return err
+	}
+
+	if err := bw.tw.Close(); err != nil {
+		return err
+	}
+
+	return bw.f.Close()
+}
+
+// ExportItem adds the cache entry of the current hash of the item to the bundle. The target
+// has to be built, so the entry has metadata and, when the target has outs, a stored archive.
+func (cm *CacheManager) ExportItem(bw *BundleWriter, project string, ci *CacheItem) error {
+	entry := &BundleEntry{
+		Project: project,
+		Key:     filepath.Join(ci.target.Package(), ci.target.Name, ci.Hash),
+	}
+
+	if bw.added[entry.metadataName()] {
+		return nil
+	}
+
+	if _, err := os.Stat(ci.MetadataPath); os.IsNotExist(err) {
+		return fmt.Errorf("%s has no cache entry for hash %s, it needs to be built first", ci.target.Qn(), ci.Hash)
+	} else if err != nil {
+		return err
+	}
+
+	var err error
+	if entry.MetadataDigest, err = bw.addFile(entry.metadataName(), ci.MetadataPath); err != nil {
+		return fmt.Errorf("adding metadata: %w", err)
+	}
+
+	if ci.OutDest != "" {
+		archivePath := filepath.Join(*cm.config.Tmp, entry.Key+".export.tar.zst")
+		if err := os.MkdirAll(filepath.Dir(archivePath), os.ModePerm); err != nil {
+			return err
+		}
+		os.Remove(archivePath)
+		defer os.Remove(archivePath)
+
+		if err := cm.io.Restore(entry.Key, archivePath)(); err != nil {
+			return fmt.Errorf("fetching archive of %s: %w", ci.target.Qn(), err)
+		}
+
+		if entry.ArchiveDigest, err = bw.addFile(entry.archiveName(), archivePath); err != nil {
+			return fmt.Errorf("adding archive: %w", err)
+		}
+	}
+
+	bw.added[entry.metadataName()] = true
+	bw.manifest.Entries = append(bw.manifest.Entries, entry)
+
+	return nil
+}
+
+// Bundle is a bundle unpacked into a temporary folder, with every file checked against the manifest
+type Bundle struct {
+	dir      string
+	manifest *BundleManifest
+}
+
+// OpenBundle unpacks the bundle and verifies the digest of every file it contains
+func OpenBundle(path string) (*Bundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dir, err := os.MkdirTemp("", "zen-bundle-")
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{dir: dir}
+
+	digests, err := b.unpack(tar.NewReader(f))
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	if err := b.verify(digests); err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *Bundle) unpack(tr *tar.Reader) (map[string]string, error) {
+	digests := map[string]string{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading bundle: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("bundle entry %s has unsupported type %c", header.Name, header.Typeflag)
+		}
+
+		if header.Name == bundleManifestName {
+			b.manifest = &BundleManifest{}
+			if err := json.NewDecoder(tr).Decode(b.manifest); err != nil {
+				return nil, fmt.Errorf("reading manifest: %w", err)
+			}
+			continue
+		}
+
+		dest, err := safeJoin(b.dir, header.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+			return nil, err
+		}
+
+		out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		h := sha256.New()
+		_, err = io.Copy(io.MultiWriter(out, h), tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unpacking %s: %w", header.Name, err)
+		}
+
+		digests[filepath.Clean(header.Name)] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+
+	return digests, nil
+}
+
+func (b *Bundle) verify(digests map[string]string) error {
+	if b.manifest == nil {
+		return fmt.Errorf("bundle has no manifest")
+	} else if b.manifest.Version != BundleVersion {
+		return fmt.Errorf("bundle version %d is not supported, expected %d", b.manifest.Version, BundleVersion)
+	}
+
+	expected := map[string]string{}
+	for _, e := range b.manifest.Entries {
+		if e.Project == "" || strings.Contains(e.Project, "/") || e.Project == ".." {
+			return fmt.Errorf("bundle entry %s has an invalid project %q", e.Key, e.Project)
+		}
+
+		expected[e.metadataName()] = e.MetadataDigest
+		if e.ArchiveDigest != "" {
+			expected[e.archiveName()] = e.ArchiveDigest
+		}
+	}
+
+	for name, digest := range expected {
+		found, ok := digests[name]
+		if !ok {
+			return fmt.Errorf("%s is missing from the bundle", name)
+		} else if found != digest {
+			return fmt.Errorf("%s has digest %s, expected %s", name, found, digest)
+		}
+	}
+
+	for name := range digests {
+		if _, ok := expected[name]; !ok {
+			return fmt.Errorf("%s is not in the bundle manifest", name)
+		}
+	}
+
+	return nil
+}
+
+func (b *Bundle) Entries() []*BundleEntry {
+	return b.manifest.Entries
+}
+
+// Close removes the unpacked bundle
+func (b *Bundle) Close() error {
+	return os.RemoveAll(b.dir)
+}
+
+// ImportEntry installs a bundle entry into this cache, after checking its metadata matches the
+// key and the archive matches the outputs recorded in the metadata
+func (cm *CacheManager) ImportEntry(b *Bundle, e *BundleEntry) error {
+	metadataPath, err := safeJoin(*cm.config.Metadata, e.Key+".json")
+	if err != nil {
+		return err
+	}
+
+	md, err := ReadMetadataFile(filepath.Join(b.dir, e.metadataName()))
+	if err != nil {
+		return err
+	}
+
+	if md.Hash != filepath.Base(e.Key) {
+		return fmt.Errorf("%s has metadata for hash %s", e.Key, md.Hash)
+	}
+
+	if e.ArchiveDigest != "" {
+		archivePath := filepath.Join(b.dir, e.archiveName())
+		found, err := archiveDigests(archivePath)
+		if err != nil {
+			return fmt.Errorf("reading archive of %s: %w", e.Key, err)
+		}
+
+		if problems := compareOutputs(md.Outputs, found); len(problems) > 0 {
+			return fmt.Errorf("archive of %s does not match its metadata: %s", e.Key, strings.Join(problems, ", "))
+		}
+
+		if err := cm.io.Save(e.Key, archivePath)(); err != nil {
+			return fmt.Errorf("saving archive of %s: %w", e.Key, err)
+		}
+	}
+
+	// the metadata goes last, since it marks the entry as a cache hit
+	if err := os.MkdirAll(filepath.Dir(metadataPath), os.ModePerm); err != nil {
+		return fmt.Errorf("creating metadata folder: %w", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(b.dir, e.metadataName()))
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(metadataPath, data, 0644)
+}
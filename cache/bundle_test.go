@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func mockBundle(t *testing.T) (string, *CacheItem) {
+	cache := MockNewCacheManager(t)
+	ci := MockBuiltCacheItem(t, cache)
+	assert.NilError(t, ci.Save())
+
+	path := filepath.Join(t.TempDir(), "cache.bundle")
+	bw, err := NewBundleWriter(path)
+	assert.NilError(t, err)
+	assert.NilError(t, cache.ExportItem(bw, "project", ci))
+	// the same entry is only added once
+	assert.NilError(t, cache.ExportItem(bw, "project", ci))
+	assert.NilError(t, bw.Close())
+
+	return path, ci
+}
+
+func TestBundleExportImport(t *testing.T) {
+	path, ci := mockBundle(t)
+
+	b, err := OpenBundle(path)
+	assert.NilError(t, err)
+	defer b.Close()
+	assert.Equal(t, len(b.Entries()), 1)
+
+	other := MockNewCacheManager(t)
+	assert.NilError(t, other.ImportEntry(b, b.Entries()[0]))
+
+	key := filepath.Join(ci.target.Package(), ci.target.Name, ci.Hash)
+	exists, err := other.io.CheckOutputsExist(key)()
+	assert.NilError(t, err)
+	assert.Assert(t, exists)
+
+	md, err := ReadMetadataFile(filepath.Join(*other.config.Metadata, key+".json"))
+	assert.NilError(t, err)
+	assert.Equal(t, md.Hash, ci.Hash)
+}
+
+func TestImportEntryChecksOutputs(t *testing.T) {
+	path, _ := mockBundle(t)
+
+	b, err := OpenBundle(path)
+	assert.NilError(t, err)
+	defer b.Close()
+
+	// metadata without inputs, that does not list every file of the archive
+	e := b.Entries()[0]
+	md, err := ReadMetadataFile(filepath.Join(b.dir, e.metadataName()))
+	assert.NilError(t, err)
+	md.Inputs = nil
+	md.Outputs = md.Outputs[:1]
+	data, err := json.Marshal(md)
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(filepath.Join(b.dir, e.metadataName()), data, 0644))
+
+	other := MockNewCacheManager(t)
+	assert.ErrorContains(t, other.ImportEntry(b, e), "does not match its metadata")
+}
+
+func TestOpenBundleRejectsTampering(t *testing.T) {
+	path, _ := mockBundle(t)
+
+	data, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	// the metadata is stored uncompressed, so it can be altered in place
+	i := bytes.Index(data, []byte(`"fqn"`))
+	assert.Assert(t, i > 0)
+	data[i+1] = 'F'
+	assert.NilError(t, os.WriteFile(path, data, 0644))
+
+	_, err = OpenBundle(path)
+	assert.ErrorContains(t, err, "has digest")
+}
+
+func TestBundleWriterAbort(t *testing.T) {
+	dir := t.TempDir()
+	bw, err := NewBundleWriter(filepath.Join(dir, "cache.bundle"))
+	assert.NilError(t, err)
+	bw.Abort()
+
+	// a failed export leaves no partial bundle behind
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 0)
+}
@@ -0,0 +1,392 @@
+package cache
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func NewCasCache() *CasCache {
+	return &CasCache{}
+}
+
+// CasCache stores every output file once, by its sha256, and keeps a manifest per cache key.
+// Archives are split into blobs on save and reassembled on restore.
+type CasCache struct {
+	root string
+}
+
+type casManifestEntry struct {
+	Name     string    `json:"name"`
+	Type     byte      `json:"type"`
+	Mode     int64     `json:"mode"`
+	ModTime  time.Time `json:"mod_time"`
+	Linkname string    `json:"linkname,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Digest   string    `json:"digest,omitempty"`
+}
+
+type casManifest struct {
+	Entries []*casManifestEntry `json:"entries"`
+}
+
+// BlobPruner is implemented by backends that share data between keys, and need to
+// remove what is no longer referenced after keys are deleted
+type BlobPruner interface {
+	Prune() (removed int, freed int64, err error)
+}
+
+func (cc *CasCache) Load(cfg map[string]string) error {
+	cc.root = cfg["artifacts"]
+	if cc.root == "" {
+		return fmt.Errorf("cas cache needs an artifacts path")
+	}
+
+	return nil
+}
+
+func (cc *CasCache) manifestPath(key string) string {
+	return filepath.Join(cc.root, "manifests", key+".json")
+}
+
+// blobPath uses the same digest as utils.FileHash, which is also recorded in the metadata outputs.
+// The digest has to be valid, as checked by isDigest.
+func (cc *CasCache) blobPath(digest string) string {
+	return filepath.Join(cc.root, "blobs", digest[:2], digest)
+}
+
+func (cc *CasCache) Save(key, fpath string) func() error {
+	return func() error {
+		f, err := os.Open(fpath)
+		if err != nil {
+			return fmt.Errorf("opening archive: %w", err)
+		}
+		defer f.Close()
+
+		zstdReader, err := zstd.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zstdReader.Close()
+
+		manifest := &casManifest{Entries: []*casManifestEntry{}}
+		tarReader := tar.NewReader(zstdReader)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("reading archive: %w", err)
+			}
+
+			entry := &casManifestEntry{
+				Name:     header.Name,
+				Type:     header.Typeflag,
+				Mode:     header.Mode,
+				ModTime:  header.ModTime,
+				Linkname: header.Linkname,
+			}
+
+			if header.Typeflag == tar.TypeReg {
+				if entry.Digest, err = cc.storeBlob(tarReader); err != nil {
+					return fmt.Errorf("storing %s: %w", header.Name, err)
+				}
+				entry.Size = header.Size
+			}
+
+			manifest.Entries = append(manifest.Entries, entry)
+		}
+
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+
+		dest := cc.manifestPath(key)
+		if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+			return fmt.Errorf("creating manifest folder: %w", err)
+		}
+
+		// the manifest is written last, so a key only exists once all of its blobs do
+		tmp := dest + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+
+		return os.Rename(tmp, dest)
+	}
+}
+
+func (cc *CasCache) storeBlob(r io.Reader) (string, error) {
+	if err := os.MkdirAll(filepath.Join(cc.root, "blobs"), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(cc.root, "blobs"), "incoming-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(tmp, io.TeeReader(r, h)); err != nil {
+		tmp.Close()
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	digest := fmt.Sprintf("%x", h.Sum(nil))
+
+	dest := cc.blobPath(digest)
+	if _, err := os.Stat(dest); err == nil {
+		// reused blobs are touched, so a concurrent prune leaves them alone until the manifest
+		// referencing them is written
+		now := time.Now()
+		return digest, os.Chtimes(dest, now, now)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	// blobs are shared between keys, so they are never modified in place
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		return "", err
+	}
+
+	return digest, os.Rename(tmp.Name(), dest)
+}
+
+func (cc *CasCache) readManifest(key string) (*casManifest, error) {
+	data, err := os.ReadFile(cc.manifestPath(key))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &casManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("reading manifest %s: %w", key, err)
+	}
+
+	// digests name the blob files, so a broken one could point anywhere
+	for _, e := range manifest.Entries {
+		if e.Type == tar.TypeReg && !isDigest(e.Digest) {
+			return nil, fmt.Errorf("manifest %s: %s has an invalid digest %q", key, e.Name, e.Digest)
+		}
+	}
+
+	return manifest, nil
+}
+
+func (cc *CasCache) Restore(key, fpath string) func() error {
+	return func() error {
+		manifest, err := cc.readManifest(key)
+		if err != nil {
+			return fmt.Errorf("fetching manifest: %w", err)
+		}
+
+		out, err := os.Create(fpath)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		zstdWriter, err := zstd.NewWriter(out, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+
+		tarWriter := tar.NewWriter(zstdWriter)
+		for _, e := range manifest.Entries {
+			if err := cc.writeEntry(tarWriter, e); err != nil {
+				return fmt.Errorf("restoring %s: %w", e.Name, err)
+			}
+		}
+
+		if err := tarWriter.Close(); err != nil {
+			return err
+		}
+
+		if err := zstdWriter.Close(); err != nil {
+			return err
+		}
+
+		return out.Close()
+	}
+}
+
+func (cc *CasCache) writeEntry(tarWriter *tar.Writer, e *casManifestEntry) error {
+	header := &tar.Header{
+		Name:     e.Name,
+		Typeflag: e.Type,
+		Mode:     e.Mode,
+		ModTime:  e.ModTime,
+		Linkname: e.Linkname,
+		Size:     e.Size,
+		Format:   tar.FormatPAX,
+	}
+
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if e.Type != tar.TypeReg {
+		return nil
+	}
+
+	blob, err := os.Open(cc.blobPath(e.Digest))
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	// the blob is checked as it is copied, so a corrupt one fails the restore
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tarWriter, h), blob); err != nil {
+		return err
+	}
+
+	if digest := fmt.Sprintf("%x", h.Sum(nil)); digest != e.Digest {
+		return fmt.Errorf("blob %s is corrupt, its content has digest %s", e.Digest, digest)
+	}
+
+	return nil
+}
+
+// Delete only removes the manifest, since blobs can be shared with other keys. Unreferenced
+// blobs are removed by Prune.
+func (cc *CasCache) Delete(key string) func() error {
+	return func() error {
+		if err := os.Remove(cc.manifestPath(key)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("deleting manifest: %w", err)
+		}
+
+		return nil
+	}
+}
+
+func (cc *CasCache) CheckOutputsExist(key string) func() (bool, error) {
+	return func() (bool, error) {
+		manifest, err := cc.readManifest(key)
+		if os.IsNotExist(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		for _, e := range manifest.Entries {
+			if e.Type != tar.TypeReg {
+				continue
+			}
+
+			if _, err := os.Stat(cc.blobPath(e.Digest)); os.IsNotExist(err) {
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+		}
+
+		return true, nil
+	}
+}
+
+// ArtifactSize counts the manifest of key and every blob it references, even the ones shared
+// with other keys. Only the manifest is freed when the key is deleted.
+func (cc *CasCache) ArtifactSize(key string) (int64, int64, error) {
+	info, err := os.Stat(cc.manifestPath(key))
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	manifest, err := cc.readManifest(key)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size := info.Size()
+	counted := map[string]bool{}
+	for _, e := range manifest.Entries {
+		if e.Type == tar.TypeReg && !counted[e.Digest] {
+			counted[e.Digest] = true
+			size += e.Size
+		}
+	}
+
+	return size, info.Size(), nil
+}
+
+// casPruneGrace is how old an unreferenced blob has to be to be pruned. Saves store or touch
+// their blobs before writing the manifest that references them, possibly from another process,
+// so recent blobs could belong to a manifest that does not exist yet.
+const casPruneGrace = time.Hour
+
+// Prune removes the blobs that no manifest references, and that were not stored or reused
+// within casPruneGrace
+func (cc *CasCache) Prune() (int, int64, error) {
+	cutoff := time.Now().Add(-casPruneGrace)
+	referenced := map[string]bool{}
+	manifests := filepath.Join(cc.root, "manifests")
+	if err := filepath.Walk(manifests, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		} else if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		key := strings.TrimSuffix(strings.TrimPrefix(path, manifests+"/"), ".json")
+		manifest, err := cc.readManifest(key)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range manifest.Entries {
+			if e.Digest != "" {
+				referenced[e.Digest] = true
+			}
+		}
+
+		return nil
+	}); err != nil && !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+
+	removed := 0
+	var freed int64
+	err := filepath.Walk(filepath.Join(cc.root, "blobs"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		} else if info.IsDir() || referenced[info.Name()] {
+			return nil
+		}
+
+		// blobs still being written, or used by a save in progress
+		if strings.HasPrefix(info.Name(), "incoming-") || info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		freed += info.Size()
+
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+
+	return removed, freed, nil
+}
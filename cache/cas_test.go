@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func mockCasArchive(t *testing.T, files map[string]string) string {
+	ci := mockOutItem(t)
+	for name, content := range files {
+		path := filepath.Join(ci.OutDest, name)
+		assert.NilError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NilError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	assert.NilError(t, os.Symlink("shared", filepath.Join(ci.OutDest, "link")))
+
+	archive := filepath.Join(t.TempDir(), "out.tar.zst")
+	assert.NilError(t, ci.Compress(archive))
+
+	return archive
+}
+
+func countBlobs(t *testing.T, root string) int {
+	count := 0
+	assert.NilError(t, filepath.Walk(filepath.Join(root, "blobs"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+		return err
+	}))
+
+	return count
+}
+
+func TestCasCacheDeduplicatesFiles(t *testing.T) {
+	root := t.TempDir()
+	cas := NewCasCache()
+	assert.NilError(t, cas.Load(map[string]string{"artifacts": root}))
+
+	first := mockCasArchive(t, map[string]string{"shared": "vendored", "bin/a": "one"})
+	second := mockCasArchive(t, map[string]string{"shared": "vendored", "bin/a": "two"})
+
+	assert.NilError(t, cas.Save("pkg/target/hash1", first)())
+	assert.NilError(t, cas.Save("pkg/target/hash2", second)())
+	assert.Equal(t, countBlobs(t, root), 3)
+
+	exists, err := cas.CheckOutputsExist("pkg/target/hash1")()
+	assert.NilError(t, err)
+	assert.Assert(t, exists)
+
+	restored := filepath.Join(t.TempDir(), "restored.tar.zst")
+	assert.NilError(t, cas.Restore("pkg/target/hash1", restored)())
+
+	original, err := os.ReadFile(first)
+	assert.NilError(t, err)
+	rebuilt, err := os.ReadFile(restored)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, rebuilt, original)
+
+	// the shared blob counts in both keys, but only the manifest is freed with the key
+	manifest, err := os.Stat(cas.manifestPath("pkg/target/hash1"))
+	assert.NilError(t, err)
+	size, own, err := cas.ArtifactSize("pkg/target/hash1")
+	assert.NilError(t, err)
+	assert.Equal(t, size, manifest.Size()+int64(len("vendored")+len("one")))
+	assert.Equal(t, own, manifest.Size())
+
+	assert.NilError(t, cas.Delete("pkg/target/hash1")())
+
+	// recent blobs might belong to a save in progress
+	removed, _, err := cas.Prune()
+	assert.NilError(t, err)
+	assert.Equal(t, removed, 0)
+
+	ageBlobs(t, root, 2*casPruneGrace)
+	removed, _, err = cas.Prune()
+	assert.NilError(t, err)
+	assert.Equal(t, removed, 1)
+	assert.Equal(t, countBlobs(t, root), 2)
+
+	exists, err = cas.CheckOutputsExist("pkg/target/hash2")()
+	assert.NilError(t, err)
+	assert.Assert(t, exists)
+}
+
+func ageBlobs(t *testing.T, root string, age time.Duration) {
+	old := time.Now().Add(-age)
+	assert.NilError(t, filepath.Walk(filepath.Join(root, "blobs"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		return os.Chtimes(path, old, old)
+	}))
+}
+
+func TestCasSaveTouchesReusedBlobs(t *testing.T) {
+	root := t.TempDir()
+	cas := NewCasCache()
+	assert.NilError(t, cas.Load(map[string]string{"artifacts": root}))
+
+	archive := mockCasArchive(t, map[string]string{"shared": "vendored"})
+	assert.NilError(t, cas.Save("pkg/target/hash1", archive)())
+	assert.NilError(t, cas.Delete("pkg/target/hash1")())
+	ageBlobs(t, root, 2*casPruneGrace)
+
+	// a save reusing the old blob races with a prune, before its manifest is written
+	assert.NilError(t, cas.Save("pkg/target/hash2", archive)())
+	assert.NilError(t, os.Remove(cas.manifestPath("pkg/target/hash2")))
+
+	removed, _, err := cas.Prune()
+	assert.NilError(t, err)
+	assert.Equal(t, removed, 0)
+	assert.Equal(t, countBlobs(t, root), 1)
+}
+
+func TestCasRestoreChecksBlobs(t *testing.T) {
+	root := t.TempDir()
+	cas := NewCasCache()
+	assert.NilError(t, cas.Load(map[string]string{"artifacts": root}))
+
+	archive := mockCasArchive(t, map[string]string{"shared": "vendored"})
+	assert.NilError(t, cas.Save("pkg/target/hash1", archive)())
+
+	// a blob changed on disk
+	blob := cas.blobPath(fmt.Sprintf("%x", sha256.Sum256([]byte("vendored"))))
+	assert.NilError(t, os.Chmod(blob, 0644))
+	assert.NilError(t, os.WriteFile(blob, []byte("tampered"), 0644))
+
+	restored := filepath.Join(t.TempDir(), "restored.tar.zst")
+	assert.ErrorContains(t, cas.Restore("pkg/target/hash1", restored)(), "is corrupt")
+
+	// a manifest pointing to a digest that is not one
+	manifest := `{"entries": [{"name": "shared", "type": 48, "mode": 420, "size": 8, "digest": "a"}]}`
+	assert.NilError(t, os.WriteFile(cas.manifestPath("pkg/target/hash1"), []byte(manifest), 0644))
+
+	_, err := cas.CheckOutputsExist("pkg/target/hash1")()
+	assert.ErrorContains(t, err, "invalid digest")
+	assert.ErrorContains(t, cas.Restore("pkg/target/hash1", restored)(), "invalid digest")
+}
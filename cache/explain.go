@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	zen_target "github.com/zen-io/zen-core/target"
+	"github.com/zen-io/zen-core/utils"
+	"golang.org/x/exp/slices"
+)
+
+// InputChange describes one hash input that differs between two builds
+type InputChange struct {
+	Kind   string // type, definition, src, dep, env, out, label, environment, script, tool or toolchain
+	Name   string
+	Before string
+	After  string
+}
+
+func (ic *InputChange) String() string {
+	switch {
+	case ic.Before == "":
+		return fmt.Sprintf("%s %s added", ic.Kind, ic.Name)
+	case ic.After == "":
+		return fmt.Sprintf("%s %s removed", ic.Kind, ic.Name)
+	default:
+		return fmt.Sprintf("%s %s changed: %s -> %s", ic.Kind, ic.Name, ic.Before, ic.After)
+	}
+}
+
+// Explanation tells why a target does not hit the cache
+type Explanation struct {
+	Fqn          string
+	Hash         string
+	PreviousHash string
+	Hit          bool
+	Changes      []*InputChange
+}
+
+func (e *Explanation) String() string {
+	var sb strings.Builder
+	switch {
+	case e.Hit:
+		sb.WriteString(fmt.Sprintf("%s: cache hit (%s)\n", e.Fqn, e.Hash))
+	case e.PreviousHash == "":
+		sb.WriteString(fmt.Sprintf("%s: never built\n", e.Fqn))
+	case len(e.Changes) == 0:
+		sb.WriteString(fmt.Sprintf("%s: hash changed from %s to %s, but no recorded input differs\n", e.Fqn, e.PreviousHash, e.Hash))
+	default:
+		sb.WriteString(fmt.Sprintf("%s: hash changed from %s to %s\n", e.Fqn, e.PreviousHash, e.Hash))
+		for _, c := range e.Changes {
+			sb.WriteString(fmt.Sprintf("  %s\n", c))
+		}
+	}
+
+	return sb.String()
+}
+
+// Diff returns the inputs that changed from prev to hi, sorted by kind and name
+func (hi *HashInputs) Diff(prev *HashInputs) []*InputChange {
+	if prev == nil {
+		prev = &HashInputs{}
+	}
+
+	changes := []*InputChange{}
+
+	changes = append(changes, diffMaps("type", map[string]string{"type": prev.Type}, map[string]string{"type": hi.Type})...)
+	changes = append(changes, diffMaps("definition", map[string]string{"block": prev.Definition}, map[string]string{"block": hi.Definition})...)
+
+	before, after := flattenSrcs(prev.Srcs), flattenSrcs(hi.Srcs)
+	changes = append(changes, diffMaps("src", before["src"], after["src"])...)
+	changes = append(changes, diffMaps("dep", utils.MergeMaps(before["dep"], prev.Deps), utils.MergeMaps(after["dep"], hi.Deps))...)
+	changes = append(changes, diffMaps("env", prev.Env, hi.Env)...)
+	changes = append(changes, diffSlices("out", prev.Outs, hi.Outs)...)
+	changes = append(changes, diffSlices("label", prev.Labels, hi.Labels)...)
+	changes = append(changes, diffSlices("environment", prev.Environments, hi.Environments)...)
+	changes = append(changes, diffMaps("script", joinSlices(prev.Scripts), joinSlices(hi.Scripts))...)
+	changes = append(changes, diffMaps("tool", prev.Tools, hi.Tools)...)
+	changes = append(changes, diffMaps("toolchain", prev.Toolchains, hi.Toolchains)...)
+
+	return changes
+}
+
+// flattenSrcs splits src hashes into files and target references, keyed by category and name
+func flattenSrcs(srcs map[string]map[string]string) map[string]map[string]string {
+	flat := map[string]map[string]string{
+		"src": {},
+		"dep": {},
+	}
+
+	for cat, hashes := range srcs {
+		for name, hash := range hashes {
+			if zen_target.IsTargetReference(name) {
+				flat["dep"][name] = hash
+			} else {
+				flat["src"][fmt.Sprintf("%s:%s", cat, name)] = hash
+			}
+		}
+	}
+
+	return flat
+}
+
+func joinSlices(m map[string][]string) map[string]string {
+	joined := map[string]string{}
+	for k, v := range m {
+		joined[k] = strings.Join(v, ",")
+	}
+
+	return joined
+}
+
+func diffMaps(kind string, before, after map[string]string) []*InputChange {
+	changes := []*InputChange{}
+	for k, v := range after {
+		if before[k] != v {
+			changes = append(changes, &InputChange{Kind: kind, Name: k, Before: before[k], After: v})
+		}
+	}
+
+	for k, v := range before {
+		if _, ok := after[k]; !ok {
+			changes = append(changes, &InputChange{Kind: kind, Name: k, Before: v})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+
+	return changes
+}
+
+func diffSlices(kind string, before, after []string) []*InputChange {
+	changes := []*InputChange{}
+	for _, v := range after {
+		if !slices.Contains(before, v) {
+			changes = append(changes, &InputChange{Kind: kind, Name: v, After: v})
+		}
+	}
+
+	for _, v := range before {
+		if !slices.Contains(after, v) {
+			changes = append(changes, &InputChange{Kind: kind, Name: v, Before: v})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+
+	return changes
+}
+
+// ExplainTarget compares the inputs of a loaded target against its last stored build
+func (cm *CacheManager) ExplainTarget(stepQn string) (*Explanation, error) {
+	ci, ok := cm.items.Get(stepQn)
+	if !ok {
+		return nil, fmt.Errorf("%s not in cache", stepQn)
+	}
+
+	exp := &Explanation{
+		Fqn:     ci.target.Qn(),
+		Hash:    ci.Hash,
+		Changes: []*InputChange{},
+	}
+
+	// explaining is not a use of the entry, so it is not touched like CheckCacheHits does
+	if _, err := os.Stat(ci.MetadataPath); err == nil {
+		exp.Hit = true
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("checking metadata: %w", err)
+	}
+
+	if exp.Hit {
+		return exp, nil
+	}
+
+	history, err := cm.TargetMetadataHistory(ci.target.Package(), ci.target.Name)
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+
+	if len(history) == 0 {
+		return exp, nil
+	}
+
+	exp.PreviousHash = history[0].Hash
+	exp.Changes = ci.inputs.Diff(history[0].Inputs)
+
+	return exp, nil
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zen-io/zen-core/mock"
+	"gotest.tools/v3/assert"
+)
+
+func TestHashInputsDiff(t *testing.T) {
+	prev := &HashInputs{
+		Srcs: map[string]map[string]string{
+			"srcs": {"a.go": "1", "b.go": "2", "//proj/pkg:dep:build": "d1"},
+		},
+		Outs:   []string{"bin"},
+		Env:    map[string]string{"GOOS": "linux", "CGO": "0"},
+		Labels: []string{"go"},
+	}
+
+	curr := &HashInputs{
+		Srcs: map[string]map[string]string{
+			"srcs": {"a.go": "1", "b.go": "3", "c.go": "4", "//proj/pkg:dep:build": "d2"},
+		},
+		Outs:   []string{"bin"},
+		Env:    map[string]string{"GOOS": "darwin"},
+		Labels: []string{"go", "release"},
+	}
+
+	assert.DeepEqual(t, curr.Diff(prev), []*InputChange{
+		{Kind: "src", Name: "srcs:b.go", Before: "2", After: "3"},
+		{Kind: "src", Name: "srcs:c.go", After: "4"},
+		{Kind: "dep", Name: "//proj/pkg:dep:build", Before: "d1", After: "d2"},
+		{Kind: "env", Name: "CGO", Before: "0"},
+		{Kind: "env", Name: "GOOS", Before: "linux", After: "darwin"},
+		{Kind: "label", Name: "release", After: "release"},
+	})
+
+	assert.Equal(t, len(curr.Diff(curr)), 0)
+}
+
+func TestExplainTargetDoesNotTouchTheEntry(t *testing.T) {
+	cache := MockNewCacheManager(t)
+	target := mock.MockBasicTarget(t)
+	mock.CreateFiles(t, mock.FileMapToSlice(t, mock.MockSrcs["basic"].ExpandSrcsMappings(target.Path())))
+
+	ci, err := cache.LoadTargetCache(target, nil)
+	assert.NilError(t, err)
+	assert.NilError(t, ci.SaveMetadata())
+
+	accessed := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	assert.NilError(t, os.Chtimes(ci.MetadataPath, accessed, accessed))
+
+	exp, err := cache.ExplainTarget(target.Qn() + ":build")
+	assert.NilError(t, err)
+	assert.Assert(t, exp.Hit)
+
+	info, err := os.Stat(ci.MetadataPath)
+	assert.NilError(t, err)
+	assert.Equal(t, info.ModTime(), accessed)
+}
@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/zen-io/zen-core/utils"
+)
+
+type FsckProblem struct {
+	Key      string
+	Source   string // archive or out
+	Problems []string
+}
+
+type FsckReport struct {
+	Checked     int
+	Unverified  []string
+	Corrupt     []*FsckProblem
+	Quarantined []string
+}
+
+// Verify recomputes the output digests of every cache entry, from its archive in the local
+// cache or, for the build whose outs are in the out folder of its target, from that folder, and
+// compares them against the metadata. With quarantine, corrupt entries are moved out of the
+// cache so they are rebuilt.
+func (cm *CacheManager) Verify(quarantine bool) (*FsckReport, error) {
+	entries, err := cm.Entries()
+	if err != nil {
+		return nil, fmt.Errorf("listing entries: %w", err)
+	}
+
+	report := &FsckReport{
+		Unverified:  []string{},
+		Corrupt:     []*FsckProblem{},
+		Quarantined: []string{},
+	}
+
+	for _, e := range entries {
+		// metadata written before inputs were recorded has no outputs to compare against
+		if e.Metadata.Inputs == nil {
+			report.Unverified = append(report.Unverified, e.Key)
+			continue
+		}
+
+		problem, err := cm.verifyEntry(e)
+		if err != nil {
+			return nil, fmt.Errorf("verifying %s: %w", e.Key, err)
+		} else if problem == nil {
+			report.Unverified = append(report.Unverified, e.Key)
+			continue
+		}
+
+		report.Checked++
+		if len(problem.Problems) == 0 {
+			continue
+		}
+		report.Corrupt = append(report.Corrupt, problem)
+
+		if quarantine {
+			if err := cm.quarantine(e); err != nil {
+				return nil, fmt.Errorf("quarantining %s: %w", e.Key, err)
+			}
+			report.Quarantined = append(report.Quarantined, e.Key)
+		}
+	}
+
+	return report, nil
+}
+
+// verifyEntry returns nil when there is nothing to verify the entry against
+func (cm *CacheManager) verifyEntry(e *CacheEntry) (*FsckProblem, error) {
+	local := cm.localIO()
+	exists, err := local.CheckOutputsExist(e.Key)()
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		archivePath := filepath.Join(*cm.config.Tmp, e.Key+".fsck.tar.zst")
+		if err := os.MkdirAll(filepath.Dir(archivePath), os.ModePerm); err != nil {
+			return nil, err
+		}
+		os.Remove(archivePath)
+		defer os.Remove(archivePath)
+
+		problem := &FsckProblem{Key: e.Key, Source: "archive", Problems: []string{}}
+		if err := local.Restore(e.Key, archivePath)(); err != nil {
+			problem.Problems = append(problem.Problems, fmt.Sprintf("fetching archive: %s", err))
+			return problem, nil
+		}
+
+		found, err := archiveDigests(archivePath)
+		if err != nil {
+			problem.Problems = append(problem.Problems, fmt.Sprintf("reading archive: %s", err))
+			return problem, nil
+		}
+
+		problem.Problems = compareOutputs(e.Metadata.Outputs, found)
+		return problem, nil
+	}
+
+	// the out folder only holds the outs of the build last copied or restored into it
+	materialized, err := os.ReadFile(filepath.Join(*cm.config.Metadata, e.target(), outMarker))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	} else if string(materialized) != e.hash() {
+		return nil, nil
+	}
+
+	outDir := filepath.Join(*cm.config.Out, e.target())
+	if _, err := os.Stat(outDir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	found, err := dirDigests(outDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FsckProblem{
+		Key:      e.Key,
+		Source:   "out",
+		Problems: compareOutputs(e.Metadata.Outputs, found),
+	}, nil
+}
+
+// quarantine moves the entry out of the cache. An entry quarantined before replaces the old one.
+func (cm *CacheManager) quarantine(e *CacheEntry) error {
+	dest := filepath.Join(filepath.Dir(*cm.config.Metadata), "quarantine", e.Key)
+	if err := os.RemoveAll(dest); err != nil {
+		return fmt.Errorf("removing previous quarantine: %w", err)
+	}
+	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := os.Rename(e.MetadataPath, filepath.Join(dest, "metadata.json")); err != nil {
+		return fmt.Errorf("moving metadata: %w", err)
+	}
+
+	buildDir := filepath.Join(*cm.config.Tmp, e.Key)
+	if _, err := os.Stat(buildDir); err == nil {
+		if err := os.Rename(buildDir, filepath.Join(dest, "build")); err != nil {
+			return fmt.Errorf("moving build folder: %w", err)
+		}
+	}
+
+	local := cm.localIO()
+	if exists, err := local.CheckOutputsExist(e.Key)(); err != nil {
+		return err
+	} else if exists {
+		if err := local.Restore(e.Key, filepath.Join(dest, "archive.tar.zst"))(); err != nil {
+			return fmt.Errorf("keeping archive: %w", err)
+		}
+		if err := cm.io.Delete(e.Key)(); err != nil {
+			return fmt.Errorf("deleting archive: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func compareOutputs(expected []*OutputMetadata, found map[string]*OutputMetadata) []string {
+	problems := []string{}
+	seen := map[string]bool{}
+
+	for _, o := range expected {
+		seen[o.Path] = true
+		f, ok := found[o.Path]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is missing", o.Path))
+		} else if f.Size != o.Size {
+			problems = append(problems, fmt.Sprintf("%s has size %d, expected %d", o.Path, f.Size, o.Size))
+		} else if f.Digest != o.Digest {
+			problems = append(problems, fmt.Sprintf("%s has digest %s, expected %s", o.Path, f.Digest, o.Digest))
+		}
+	}
+
+	unexpected := []string{}
+	for p := range found {
+		if !seen[p] {
+			unexpected = append(unexpected, fmt.Sprintf("%s is not in the metadata", p))
+		}
+	}
+	sort.Strings(unexpected)
+
+	return append(problems, unexpected...)
+}
+
+func archiveDigests(path string) (map[string]*OutputMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zstdReader, err := zstd.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zstdReader.Close()
+
+	found := map[string]*OutputMetadata{}
+	tarReader := tar.NewReader(zstdReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if !header.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		h := sha256.New()
+		size, err := io.Copy(h, tarReader)
+		if err != nil {
+			return nil, err
+		}
+
+		found[filepath.Clean(header.Name)] = &OutputMetadata{
+			Path:   filepath.Clean(header.Name),
+			Size:   size,
+			Digest: fmt.Sprintf("%x", h.Sum(nil)),
+		}
+	}
+
+	return found, nil
+}
+
+func dirDigests(root string) (map[string]*OutputMetadata, error) {
+	found := map[string]*OutputMetadata{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		digest, err := utils.FileHash(path)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		found[rel] = &OutputMetadata{Path: rel, Size: info.Size(), Digest: digest}
+		return nil
+	})
+
+	return found, err
+}
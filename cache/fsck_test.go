@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestVerify(t *testing.T) {
+	cache := MockNewCacheManager(t)
+	ci := MockBuiltCacheItem(t, cache)
+	target := ci.target
+
+	// the out folder holds the outs of another build
+	assert.NilError(t, os.MkdirAll(filepath.Dir(ci.outMarkerPath()), os.ModePerm))
+	assert.NilError(t, os.WriteFile(ci.outMarkerPath(), []byte("other"), 0644))
+	report, err := cache.Verify(false)
+	assert.NilError(t, err)
+	assert.Equal(t, report.Checked, 0)
+	assert.DeepEqual(t, report.Unverified, []string{filepath.Join(target.Package(), target.Name, ci.Hash)})
+
+	// without an archive, the out folder is verified
+	assert.NilError(t, ci.markOut())
+	report, err = cache.Verify(false)
+	assert.NilError(t, err)
+	assert.Equal(t, report.Checked, 1)
+	assert.Equal(t, len(report.Corrupt), 0)
+
+	assert.NilError(t, os.WriteFile(filepath.Join(ci.BuildOutPath(), "hello1"), []byte("hel"), 0644))
+	report, err = cache.Verify(false)
+	assert.NilError(t, err)
+	assert.Equal(t, len(report.Corrupt), 1)
+	assert.Equal(t, report.Corrupt[0].Source, "out")
+	assert.DeepEqual(t, report.Corrupt[0].Problems, []string{"hello1 has size 3, expected 5"})
+
+	// a truncated archive is corrupt, and gets quarantined
+	assert.NilError(t, ci.Save())
+	artifact := filepath.Join(*cache.config.Artifacts, target.Package(), target.Name, ci.Hash+".tar.zst")
+	assert.NilError(t, os.Truncate(artifact, 10))
+
+	// quarantined before, and built again
+	quarantined := filepath.Join(filepath.Dir(*cache.config.Metadata), "quarantine", target.Package(), target.Name, ci.Hash)
+	for _, dir := range []string{ci.BuildCachePath(), filepath.Join(quarantined, "build")} {
+		assert.NilError(t, os.MkdirAll(dir, os.ModePerm))
+		assert.NilError(t, os.WriteFile(filepath.Join(dir, "partial.o"), []byte("partial"), 0644))
+	}
+
+	report, err = cache.Verify(true)
+	assert.NilError(t, err)
+	assert.Equal(t, len(report.Corrupt), 1)
+	assert.Equal(t, report.Corrupt[0].Source, "archive")
+	assert.DeepEqual(t, report.Quarantined, []string{filepath.Join(target.Package(), target.Name, ci.Hash)})
+
+	assert.Assert(t, !ci.CheckCacheHits())
+	exists, err := ci.CheckOutputsExist()
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+}
+
+func TestVerifyOnlyReadsTheLocalTier(t *testing.T) {
+	cache := MockNewCacheManager(t)
+	ci := MockBuiltCacheItem(t, cache)
+	target := ci.target
+
+	// only the shared tier has the archive
+	local, shared := t.TempDir(), t.TempDir()
+	archive := filepath.Join(t.TempDir(), "archive.tar.zst")
+	assert.NilError(t, ci.Compress(archive))
+	assert.NilError(t, (&LocalCache{root: shared}).Save(filepath.Join(target.Package(), target.Name, ci.Hash), archive)())
+	cache.io = NewTieredCache(
+		&CacheTier{Name: "local", IO: &LocalCache{root: local}, Evict: true},
+		&CacheTier{Name: "shared", IO: &LocalCache{root: shared}, ReadOnly: true},
+	)
+
+	report, err := cache.Verify(false)
+	assert.NilError(t, err)
+	assert.Equal(t, report.Checked, 0)
+
+	_, err = os.Stat(filepath.Join(local, target.Package(), target.Name, ci.Hash+".tar.zst"))
+	assert.Assert(t, os.IsNotExist(err))
+}
@@ -0,0 +1,337 @@
+package cache
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheEntry is one stored build of a target, as found in the metadata folder
+type CacheEntry struct {
+	Key          string // pkg/name/hash
+	MetadataPath string
+	Metadata     *CacheMetadata
+	LastAccess   time.Time
+	// Size is the disk space used by the artifact of the entry in the local cache
+	Size int64
+
+	// ownSize is the part of Size that deleting the artifact frees, without pruning
+	ownSize int64
+}
+
+// ArtifactSizer is implemented by backends that keep their artifacts on the local disk.
+// size is the space used by the artifact of key, and own the part of it that is not shared
+// with other keys.
+type ArtifactSizer interface {
+	ArtifactSize(key string) (size, own int64, err error)
+}
+
+func (ce *CacheEntry) target() string {
+	return filepath.Dir(ce.Key)
+}
+
+func (ce *CacheEntry) hash() string {
+	return filepath.Base(ce.Key)
+}
+
+type GCReport struct {
+	Evicted    []*CacheEntry
+	Kept       int
+	FreedBytes int64
+}
+
+// Touch marks the cache item as used, which keeps it from being garbage collected first.
+// The access time is the modification time of the metadata file.
+func (ci *CacheItem) Touch() error {
+	now := time.Now()
+	return os.Chtimes(ci.MetadataPath, now, now)
+}
+
+// Entries lists every build stored in the project cache
+func (cm *CacheManager) Entries() ([]*CacheEntry, error) {
+	entries := []*CacheEntry{}
+	err := cm.WalkMetadata(func(path string, md *CacheMetadata) error {
+		rel, err := filepath.Rel(*cm.config.Metadata, path)
+		if err != nil {
+			return err
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		entry := &CacheEntry{
+			Key:          strings.TrimSuffix(rel, ".json"),
+			MetadataPath: path,
+			Metadata:     md,
+			LastAccess:   info.ModTime(),
+		}
+
+		if sizer, ok := cm.localIO().(ArtifactSizer); ok {
+			if entry.Size, entry.ownSize, err = sizer.ArtifactSize(entry.Key); err != nil {
+				return fmt.Errorf("sizing artifact of %s: %w", entry.Key, err)
+			}
+		}
+
+		entries = append(entries, entry)
+		return nil
+	})
+
+	return entries, err
+}
+
+// GarbageCollect evicts the entries that break the gc settings of the cache block:
+// the ones over keep_last per target, the ones not accessed for max_age, and then the
+// least recently used ones until the cache fits in max_size.
+// Entries loaded in the current run are never evicted.
+func (cm *CacheManager) GarbageCollect(dryRun bool) (*GCReport, error) {
+	maxSize, err := ParseSize(cm.config.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("parsing max_size: %w", err)
+	}
+
+	maxAge, err := ParseAge(cm.config.MaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("parsing max_age: %w", err)
+	}
+
+	entries, err := cm.Entries()
+	if err != nil {
+		return nil, fmt.Errorf("listing entries: %w", err)
+	}
+
+	inUse := map[string]bool{}
+	cm.items.Iterate(func(_ string, ci *CacheItem) {
+		inUse[ci.Hash] = true
+	})
+
+	// most recently used first
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].LastAccess.After(entries[j].LastAccess)
+	})
+
+	report := &GCReport{Evicted: []*CacheEntry{}}
+	evict := map[string]bool{}
+	perTarget := map[string]int{}
+	var total int64
+
+	for _, e := range entries {
+		if inUse[e.hash()] {
+			total += e.Size
+			continue
+		}
+
+		perTarget[e.target()]++
+		if cm.config.KeepLast != nil && *cm.config.KeepLast > 0 && perTarget[e.target()] > *cm.config.KeepLast {
+			evict[e.Key] = true
+		} else if maxAge > 0 && time.Since(e.LastAccess) > maxAge {
+			evict[e.Key] = true
+		} else {
+			total += e.Size
+		}
+	}
+
+	if maxSize > 0 {
+		for i := len(entries) - 1; i >= 0 && total > maxSize; i-- {
+			if e := entries[i]; !evict[e.Key] && !inUse[e.hash()] {
+				evict[e.Key] = true
+				total -= e.Size
+			}
+		}
+	}
+
+	for _, e := range entries {
+		if !evict[e.Key] {
+			report.Kept++
+			continue
+		}
+
+		if !dryRun {
+			if err := cm.evict(e); err != nil {
+				return nil, fmt.Errorf("evicting %s: %w", e.Key, err)
+			}
+		}
+
+		// shared blobs are only freed by the prune below
+		report.Evicted = append(report.Evicted, e)
+		report.FreedBytes += e.ownSize
+	}
+
+	if !dryRun {
+		if err := cm.removeOrphanOuts(); err != nil {
+			return nil, err
+		}
+
+		if pruner, ok := cm.io.(BlobPruner); ok {
+			_, freed, err := pruner.Prune()
+			if err != nil {
+				return nil, fmt.Errorf("pruning blobs: %w", err)
+			}
+			report.FreedBytes += freed
+		}
+	}
+
+	return report, nil
+}
+
+func (cm *CacheManager) evict(e *CacheEntry) error {
+	if err := cm.io.Delete(e.Key)(); err != nil {
+		return fmt.Errorf("deleting artifact: %w", err)
+	}
+
+	if err := os.RemoveAll(filepath.Join(*cm.config.Tmp, e.Key)); err != nil {
+		return fmt.Errorf("deleting build folder: %w", err)
+	}
+
+	if err := os.Remove(e.MetadataPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("deleting metadata: %w", err)
+	}
+
+	return nil
+}
+
+// removeOrphanOuts deletes the out folders of targets that have no builds left in the metadata
+func (cm *CacheManager) removeOrphanOuts() error {
+	entries, err := cm.Entries()
+	if err != nil {
+		return err
+	}
+
+	targets := map[string]bool{}
+	for _, e := range entries {
+		targets[e.target()] = true
+	}
+
+	orphans := []string{}
+	err = filepath.WalkDir(*cm.config.Metadata, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || path == *cm.config.Metadata {
+			return err
+		}
+
+		rel, err := filepath.Rel(*cm.config.Metadata, path)
+		if err != nil {
+			return err
+		}
+
+		// the out marker does not keep a target around
+		if isEmpty, err := dirIsEmpty(path, outMarker); err != nil {
+			return err
+		} else if isEmpty && !targets[rel] {
+			orphans = append(orphans, rel)
+		}
+
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, o := range orphans {
+		if err := os.RemoveAll(filepath.Join(*cm.config.Out, o)); err != nil {
+			return fmt.Errorf("deleting out folder: %w", err)
+		}
+		if err := os.Remove(filepath.Join(*cm.config.Metadata, o, outMarker)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("deleting out marker: %w", err)
+		}
+		if err := os.Remove(filepath.Join(*cm.config.Metadata, o)); err != nil {
+			return fmt.Errorf("deleting metadata folder: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// dirIsEmpty tells if path has no entries other than the ignored ones
+func dirIsEmpty(path string, ignored ...string) (bool, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return false, err
+	}
+
+	for _, e := range entries {
+		isIgnored := false
+		for _, i := range ignored {
+			isIgnored = isIgnored || e.Name() == i
+		}
+		if !isIgnored {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+var sizeUnits = map[string]int64{
+	"":   1,
+	"B":  1,
+	"K":  1 << 10,
+	"KB": 1 << 10,
+	"M":  1 << 20,
+	"MB": 1 << 20,
+	"G":  1 << 30,
+	"GB": 1 << 30,
+	"T":  1 << 40,
+	"TB": 1 << 40,
+}
+
+// ParseSize parses sizes like "512MB" or "10G" into bytes. Units are powers of 1024.
+func ParseSize(size *string) (int64, error) {
+	if size == nil || *size == "" {
+		return 0, nil
+	}
+
+	s := strings.ToUpper(strings.TrimSpace(*size))
+	idx := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if idx == -1 {
+		idx = len(s)
+	}
+
+	unit, ok := sizeUnits[strings.TrimSpace(s[idx:])]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in %s", *size)
+	}
+
+	value, err := strconv.ParseFloat(s[:idx], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid size", *size)
+	}
+
+	return int64(value * float64(unit)), nil
+}
+
+var ageUnits = map[string]time.Duration{
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// ParseAge parses ages like "30d", "2w" or "36h" into a duration. Days and weeks are taken on
+// top of the units of time.ParseDuration.
+func ParseAge(age *string) (time.Duration, error) {
+	if age == nil {
+		return 0, nil
+	}
+
+	s := strings.TrimSpace(*age)
+	if s == "" {
+		return 0, nil
+	}
+
+	if unit, ok := ageUnits[s[len(s)-1:]]; ok {
+		value, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s is not a valid age", *age)
+		}
+
+		return time.Duration(value * float64(unit)), nil
+	}
+
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zen-io/zen-core/utils"
+	"gotest.tools/v3/assert"
+)
+
+// mockCacheEntry stores an entry whose artifact takes size bytes. Its outputs and build folder
+// take more, since the artifact is compressed and the build folder is not part of the cache.
+func mockCacheEntry(t *testing.T, cm *CacheManager, key string, size int64, accessed time.Time) {
+	md := &CacheMetadata{
+		Hash:    filepath.Base(key),
+		Outputs: []*OutputMetadata{{Path: "out", Size: 10 * size}},
+	}
+	data, err := json.Marshal(md)
+	assert.NilError(t, err)
+
+	mdPath := filepath.Join(*cm.config.Metadata, key+".json")
+	assert.NilError(t, os.MkdirAll(filepath.Dir(mdPath), os.ModePerm))
+	assert.NilError(t, os.WriteFile(mdPath, data, 0644))
+	assert.NilError(t, os.Chtimes(mdPath, accessed, accessed))
+
+	artifact := filepath.Join(*cm.config.Artifacts, key+".tar.zst")
+	assert.NilError(t, os.MkdirAll(filepath.Dir(artifact), os.ModePerm))
+	assert.NilError(t, os.WriteFile(artifact, make([]byte, size), 0644))
+
+	assert.NilError(t, os.MkdirAll(filepath.Join(*cm.config.Tmp, key), os.ModePerm))
+	assert.NilError(t, os.WriteFile(filepath.Join(*cm.config.Tmp, key, "main.o"), make([]byte, size), 0644))
+	assert.NilError(t, os.MkdirAll(filepath.Join(*cm.config.Out, filepath.Dir(key)), os.ModePerm))
+}
+
+func evictedKeys(report *GCReport) []string {
+	keys := []string{}
+	for _, e := range report.Evicted {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
+
+func TestGarbageCollect(t *testing.T) {
+	cm := MockNewCacheManager(t)
+	now := time.Now()
+
+	mockCacheEntry(t, cm, "pkg/a/1", 100, now.Add(-3*time.Hour))
+	mockCacheEntry(t, cm, "pkg/a/2", 100, now.Add(-2*time.Hour))
+	mockCacheEntry(t, cm, "pkg/a/3", 100, now.Add(-1*time.Hour))
+	mockCacheEntry(t, cm, "pkg/b/1", 100, now.Add(-48*time.Hour))
+	mockCacheEntry(t, cm, "pkg/c/1", 100, now.Add(-30*time.Minute))
+
+	cm.config.KeepLast = func(i int) *int { return &i }(2)
+	cm.config.MaxAge = utils.StringPtr("24h")
+	cm.config.MaxSize = utils.StringPtr("250B")
+
+	report, err := cm.GarbageCollect(true)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, evictedKeys(report), []string{"pkg/a/2", "pkg/a/1", "pkg/b/1"})
+
+	// dry runs do not touch anything
+	entries, err := cm.Entries()
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 5)
+
+	report, err = cm.GarbageCollect(false)
+	assert.NilError(t, err)
+	assert.Equal(t, report.Kept, 2)
+	assert.Equal(t, report.FreedBytes, int64(300))
+
+	_, err = os.Stat(filepath.Join(*cm.config.Tmp, "pkg/a/1"))
+	assert.Assert(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(*cm.config.Artifacts, "pkg/a/1.tar.zst"))
+	assert.Assert(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(*cm.config.Out, "pkg/b"))
+	assert.Assert(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(*cm.config.Out, "pkg/a"))
+	assert.NilError(t, err)
+}
+
+func TestParseSize(t *testing.T) {
+	for in, expected := range map[string]int64{
+		"":      0,
+		"100":   100,
+		"1KB":   1024,
+		"1.5k":  1536,
+		"10 GB": 10 << 30,
+	} {
+		size, err := ParseSize(utils.StringPtr(in))
+		assert.NilError(t, err)
+		assert.Equal(t, size, expected, in)
+	}
+
+	_, err := ParseSize(utils.StringPtr("10 parsecs"))
+	assert.ErrorContains(t, err, "unknown unit")
+}
+
+func TestParseAge(t *testing.T) {
+	for in, expected := range map[string]time.Duration{
+		"":    0,
+		"36h": 36 * time.Hour,
+		"30d": 30 * 24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+		".5d": 12 * time.Hour,
+	} {
+		age, err := ParseAge(utils.StringPtr(in))
+		assert.NilError(t, err)
+		assert.Equal(t, age, expected, in)
+	}
+
+	age, err := ParseAge(utils.StringPtr("  "))
+	assert.NilError(t, err)
+	assert.Equal(t, age, time.Duration(0))
+
+	_, err = ParseAge(utils.StringPtr("ad"))
+	assert.ErrorContains(t, err, "not a valid age")
+}
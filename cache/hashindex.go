@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/zen-io/zen-core/utils"
+)
+
+// files modified this close to being hashed could still change without moving their mtime,
+// so their hashes are not remembered
+const racyHashWindow = 2 * time.Second
+
+type fileHashEntry struct {
+	Inode   uint64 `json:"inode"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Hash    string `json:"hash"`
+}
+
+// FileHashIndex remembers file hashes across runs, keyed by path and invalidated
+// whenever the inode, size or modification time of the file change. Without a path,
+// hashes are only remembered in memory.
+type FileHashIndex struct {
+	path  string
+	force bool
+
+	mu      sync.Mutex
+	loaded  bool
+	dirty   bool
+	entries map[string]*fileHashEntry
+	seen    map[string]bool
+}
+
+func NewFileHashIndex(path string, force bool) *FileHashIndex {
+	return &FileHashIndex{
+		path:    path,
+		force:   force,
+		entries: map[string]*fileHashEntry{},
+		seen:    map[string]bool{},
+	}
+}
+
+func (fhi *FileHashIndex) load() {
+	if fhi.loaded || fhi.path == "" {
+		return
+	}
+	fhi.loaded = true
+
+	data, err := os.ReadFile(fhi.path)
+	if err != nil {
+		return
+	}
+
+	// a broken index is only a cache miss
+	entries := map[string]*fileHashEntry{}
+	if err := json.Unmarshal(data, &entries); err == nil {
+		fhi.entries = entries
+	}
+}
+
+// Hash returns the sha256 of the file, reusing the indexed hash when the file did not change
+func (fhi *FileHashIndex) Hash(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	entry := &fileHashEntry{
+		Inode:   fileInode(info),
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+	}
+
+	fhi.mu.Lock()
+	fhi.load()
+	fhi.seen[key] = true
+	cached, ok := fhi.entries[key]
+	fhi.mu.Unlock()
+
+	if ok && !fhi.force && cached.Inode == entry.Inode && cached.Size == entry.Size && cached.ModTime == entry.ModTime {
+		return cached.Hash, nil
+	}
+
+	start := time.Now()
+	if entry.Hash, err = utils.FileHash(path); err != nil {
+		return "", err
+	}
+
+	fhi.mu.Lock()
+	defer fhi.mu.Unlock()
+	if start.Sub(info.ModTime()) < racyHashWindow {
+		delete(fhi.entries, key)
+	} else {
+		fhi.entries[key] = entry
+	}
+	fhi.dirty = true
+
+	return entry.Hash, nil
+}
+
+// Save persists the index, dropping the entries of files that no longer exist
+func (fhi *FileHashIndex) Save() error {
+	fhi.mu.Lock()
+	defer fhi.mu.Unlock()
+
+	if !fhi.dirty || fhi.path == "" {
+		return nil
+	}
+
+	for p := range fhi.entries {
+		if fhi.seen[p] {
+			continue
+		}
+
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			delete(fhi.entries, p)
+		}
+	}
+
+	data, err := json.Marshal(fhi.entries)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fhi.path), os.ModePerm); err != nil {
+		return fmt.Errorf("creating index folder: %w", err)
+	}
+
+	tmp := fhi.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, fhi.path); err != nil {
+		return err
+	}
+	fhi.dirty = false
+
+	return nil
+}
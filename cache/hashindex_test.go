@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func mockIndexedFile(t *testing.T, content string, mtime time.Time) string {
+	path := filepath.Join(t.TempDir(), "src")
+	assert.NilError(t, os.WriteFile(path, []byte(content), 0644))
+	assert.NilError(t, os.Chtimes(path, mtime, mtime))
+
+	return path
+}
+
+func TestFileHashIndexReusesUnchangedFiles(t *testing.T) {
+	mtime := time.Now().Add(-time.Hour)
+	src := mockIndexedFile(t, "hello", mtime)
+	indexPath := filepath.Join(t.TempDir(), "files.idx")
+
+	index := NewFileHashIndex(indexPath, false)
+	h, err := index.Hash(src)
+	assert.NilError(t, err)
+	assert.NilError(t, index.Save())
+
+	// same size and mtime, so the indexed hash is trusted
+	assert.NilError(t, os.WriteFile(src, []byte("world"), 0644))
+	assert.NilError(t, os.Chtimes(src, mtime, mtime))
+
+	index = NewFileHashIndex(indexPath, false)
+	cached, err := index.Hash(src)
+	assert.NilError(t, err)
+	assert.Equal(t, cached, h)
+
+	forced := NewFileHashIndex(indexPath, true)
+	rehashed, err := forced.Hash(src)
+	assert.NilError(t, err)
+	assert.Assert(t, rehashed != h)
+
+	// a new mtime invalidates the entry
+	later := mtime.Add(time.Minute)
+	assert.NilError(t, os.Chtimes(src, later, later))
+	index = NewFileHashIndex(indexPath, false)
+	changed, err := index.Hash(src)
+	assert.NilError(t, err)
+	assert.Equal(t, changed, rehashed)
+}
+
+func TestFileHashIndexSkipsRecentFiles(t *testing.T) {
+	src := mockIndexedFile(t, "hello", time.Now())
+	index := NewFileHashIndex(filepath.Join(t.TempDir(), "files.idx"), false)
+
+	_, err := index.Hash(src)
+	assert.NilError(t, err)
+	assert.Equal(t, len(index.entries), 0)
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+func NewHttpCache() *HttpCache {
+	return &HttpCache{
+		client: &http.Client{},
+	}
+}
+
+// HttpCache stores archives in a remote cache, using GET/PUT/HEAD/DELETE on <url>/ac/<digest>,
+// where digest is the hex sha256 of the cache key. Keys name a build of a target rather than the
+// content of its archive, so they go to the action cache: servers check that /cas/ entries
+// match their digest.
+type HttpCache struct {
+	url    string
+	client *http.Client
+}
+
+func (hc *HttpCache) Load(cfg map[string]string) error {
+	if cfg["url"] == "" {
+		return fmt.Errorf("http cache needs an url")
+	}
+
+	u, err := url.Parse(cfg["url"])
+	if err != nil {
+		return fmt.Errorf("parsing http cache url: %w", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("http cache url %s needs an http or https scheme", cfg["url"])
+	}
+	hc.url = strings.TrimSuffix(u.String(), "/")
+
+	if val, ok := cfg["timeout"]; ok {
+		timeout, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("parsing http cache timeout: %w", err)
+		}
+		hc.client.Timeout = timeout
+	}
+
+	return nil
+}
+
+func (hc *HttpCache) keyUrl(key string) string {
+	return fmt.Sprintf("%s/ac/%x", hc.url, sha256.Sum256([]byte(key)))
+}
+
+func (hc *HttpCache) do(method, key string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, hc.keyUrl(key), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	return hc.client.Do(req)
+}
+
+func (hc *HttpCache) Save(key, fpath string) func() error {
+	return func() error {
+		f, err := os.Open(fpath)
+		if err != nil {
+			return fmt.Errorf("opening archive: %w", err)
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("reading archive info: %w", err)
+		}
+
+		resp, err := hc.do(http.MethodPut, key, f, info.Size())
+		if err != nil {
+			return fmt.Errorf("uploading %s: %w", key, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("uploading %s: unexpected status %s", key, resp.Status)
+		}
+
+		return nil
+	}
+}
+
+func (hc *HttpCache) Restore(key, fpath string) func() error {
+	return func() error {
+		resp, err := hc.do(http.MethodGet, key, nil, 0)
+		if err != nil {
+			return fmt.Errorf("downloading %s: %w", key, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("downloading %s: unexpected status %s", key, resp.Status)
+		}
+
+		f, err := os.Create(fpath)
+		if err != nil {
+			return fmt.Errorf("creating archive: %w", err)
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(f, resp.Body); err != nil {
+			return fmt.Errorf("downloading %s: %w", key, err)
+		}
+
+		return f.Close()
+	}
+}
+
+func (hc *HttpCache) Delete(key string) func() error {
+	return func() error {
+		resp, err := hc.do(http.MethodDelete, key, nil, 0)
+		if err != nil {
+			return fmt.Errorf("deleting %s: %w", key, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotFound {
+			return nil
+		} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("deleting %s: unexpected status %s", key, resp.Status)
+		}
+
+		return nil
+	}
+}
+
+func (hc *HttpCache) CheckOutputsExist(key string) func() (bool, error) {
+	return func() (bool, error) {
+		resp, err := hc.do(http.MethodHead, key, nil, 0)
+		if err != nil {
+			return false, fmt.Errorf("checking %s: %w", key, err)
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return true, nil
+		case http.StatusNotFound:
+			return false, nil
+		default:
+			return false, fmt.Errorf("checking %s: unexpected status %s", key, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/zen-io/zen-core/mock"
+	"github.com/zen-io/zen-core/utils"
+	"gotest.tools/v3/assert"
+)
+
+// acPath matches the action cache paths of remote caches: /ac/ and a hex sha256
+var acPath = regexp.MustCompile(`^/ac/[0-9a-f]{64}$`)
+
+func mockHttpCacheServer(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	store := map[string][]byte{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acPath.MatchString(r.URL.Path) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			store[r.URL.Path] = data
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet, http.MethodHead:
+			data, ok := store[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case http.MethodDelete:
+			delete(store, r.URL.Path)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestHttpCacheLoad(t *testing.T) {
+	assert.ErrorContains(t, NewHttpCache().Load(map[string]string{}), "needs an url")
+	assert.ErrorContains(t, NewHttpCache().Load(map[string]string{"url": "ftp://cache"}), "scheme")
+	assert.ErrorContains(t, NewHttpCache().Load(map[string]string{"url": "http://cache", "timeout": "soon"}), "timeout")
+	assert.NilError(t, NewHttpCache().Load(map[string]string{"url": "http://cache/", "timeout": "5s"}))
+}
+
+func TestHttpCacheKeyUrl(t *testing.T) {
+	hc := NewHttpCache()
+	assert.NilError(t, hc.Load(map[string]string{"url": "http://cache/zen/"}))
+	assert.Equal(t, hc.keyUrl("path/to/pkg/basic/28c9"), "http://cache/zen/ac/7977dda052565ed9fda2ae79a68dbc8e6afd5c85d8d14baee68724fa6614e910")
+}
+
+func TestHttpCacheSaveRestore(t *testing.T) {
+	srv := mockHttpCacheServer(t)
+	root := t.TempDir()
+
+	cache, err := NewCacheManager(&CacheConfig{
+		Tmp:       utils.StringPtr(filepath.Join(root, "tmp")),
+		Metadata:  utils.StringPtr(filepath.Join(root, "metadata")),
+		Out:       utils.StringPtr(filepath.Join(root, "out")),
+		Exec:      utils.StringPtr(filepath.Join(root, "exec")),
+		Artifacts: utils.StringPtr(filepath.Join(root, "artifacts")),
+		Type:      utils.StringPtr("http"),
+		Config:    map[string]string{"url": srv.URL},
+	})
+	assert.NilError(t, err)
+
+	target := mock.MockBasicTarget(t)
+	srcMappings := mock.MockSrcs["basic"].ExpandSrcsMappings(target.Path())
+	mock.CreateFiles(t, mock.FileMapToSlice(t, srcMappings))
+
+	ci, err := cache.LoadTargetCache(target, nil)
+	assert.NilError(t, err)
+
+	exists, err := ci.CheckOutputsExist()
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+
+	outFile := filepath.Join(ci.BuildOutPath(), "hello1")
+	assert.NilError(t, os.MkdirAll(filepath.Dir(outFile), os.ModePerm))
+	assert.NilError(t, os.WriteFile(outFile, []byte("hello"), 0644))
+
+	assert.NilError(t, ci.Save())
+	exists, err = ci.CheckOutputsExist()
+	assert.NilError(t, err)
+	assert.Assert(t, exists)
+
+	assert.NilError(t, os.RemoveAll(ci.BuildOutPath()))
+	assert.NilError(t, ci.Restore())
+
+	content, err := os.ReadFile(outFile)
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "hello")
+
+	assert.NilError(t, ci.Delete())
+	exists, err = ci.CheckOutputsExist()
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+	assert.ErrorContains(t, ci.Restore(), "404")
+}
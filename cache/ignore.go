@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/zen-io/zen-core/utils"
+)
+
+// IgnoreFile lists the paths left out of directory srcs, at project and package level
+const IgnoreFile = ".zenignore"
+
+// ignoreRules are the doublestar patterns of an ignore file. Patterns without a slash match
+// a name at any depth, the others match paths relative to the folder of the ignore file.
+type ignoreRules struct {
+	root     string
+	patterns []string
+}
+
+func loadIgnoreRules(root string) (*ignoreRules, error) {
+	rules := &ignoreRules{root: root}
+
+	path := filepath.Join(root, IgnoreFile)
+	lines, err := utils.ReadExclusionFile(path)
+	if os.IsNotExist(err) {
+		return rules, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pattern := strings.TrimSuffix(line, "/")
+		if !strings.Contains(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		pattern = strings.TrimPrefix(pattern, "/")
+
+		if !doublestar.ValidatePattern(pattern) {
+			return nil, fmt.Errorf("invalid pattern %q in %s", line, path)
+		}
+		rules.patterns = append(rules.patterns, pattern)
+	}
+
+	return rules, nil
+}
+
+func (ir *ignoreRules) match(path string) bool {
+	if ir == nil || len(ir.patterns) == 0 {
+		return false
+	}
+
+	rel, err := filepath.Rel(ir.root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, p := range ir.patterns {
+		if ok, _ := doublestar.Match(p, rel); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expandDirSrc lists the files under dir, keyed by their path relative to it, leaving out
+// whatever the project or package ignore files match
+func (cm *CacheManager) expandDirSrc(pkgPath, dir string) (map[string]string, error) {
+	cm.projectIgnoreOnce.Do(func() {
+		if cm.projectRoot != "" {
+			cm.projectIgnore, cm.projectIgnoreErr = loadIgnoreRules(cm.projectRoot)
+		}
+	})
+	if cm.projectIgnoreErr != nil {
+		return nil, fmt.Errorf("loading project ignore rules: %w", cm.projectIgnoreErr)
+	}
+
+	// a package can have many directory srcs, so its rules are only read once
+	pkgIgnore, ok := cm.pkgIgnores.Get(pkgPath)
+	if !ok {
+		var err error
+		if pkgIgnore, err = loadIgnoreRules(pkgPath); err != nil {
+			return nil, fmt.Errorf("loading package ignore rules: %w", err)
+		}
+		cm.pkgIgnores.Put(pkgPath, pkgIgnore)
+	}
+
+	files := map[string]string{}
+	ignores := []*ignoreRules{cm.projectIgnore, pkgIgnore}
+	if err := walkDirSrc(dir, dir, ignores, map[string]bool{}, files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// walkDirSrc adds the files under walked to files, keyed by their path relative to root.
+// Links to folders are followed as if the folder was in their place, unless the folder is
+// one of the ones being walked, which would never end.
+func walkDirSrc(root, walked string, ignores []*ignoreRules, walking map[string]bool, files map[string]string) error {
+	real, err := filepath.EvalSymlinks(walked)
+	if err != nil {
+		return err
+	} else if walking[real] {
+		return fmt.Errorf("%s links to %s, which contains it", walked, real)
+	}
+	walking[real] = true
+	defer delete(walking, real)
+
+	// WalkDir does not follow a link to a folder, so the folder is walked instead
+	return filepath.WalkDir(real, func(realPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if realPath == real {
+			return nil
+		}
+
+		rel, err := filepath.Rel(real, realPath)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(walked, rel)
+
+		for _, ir := range ignores {
+			if !ir.match(path) {
+				continue
+			} else if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			if info, err := os.Stat(realPath); err != nil {
+				return err
+			} else if info.IsDir() {
+				return walkDirSrc(root, path, ignores, walking, files)
+			}
+		}
+
+		if rel, err = filepath.Rel(root, path); err != nil {
+			return err
+		}
+		files[rel] = path
+
+		return nil
+	})
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	zen_target "github.com/zen-io/zen-core/target"
+	"gotest.tools/v3/assert"
+)
+
+func TestDirSrcsHonorIgnoreFiles(t *testing.T) {
+	project := t.TempDir()
+	pkg := filepath.Join(project, "path", "to", "pkg")
+
+	for name, content := range map[string]string{
+		"app/index.js":                        "index",
+		"app/lib/util.js":                     "util",
+		"app/debug.log":                       "log",
+		"app/node_modules/dep/index.js":       "dep",
+		"app/.terraform/providers/aws/plugin": "plugin",
+	} {
+		path := filepath.Join(pkg, name)
+		assert.NilError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NilError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	assert.NilError(t, os.WriteFile(filepath.Join(project, IgnoreFile), []byte("# vendored\nnode_modules/\n.terraform\n"), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(pkg, IgnoreFile), []byte("app/*.log\n"), 0644))
+
+	target := zen_target.NewTarget("app", zen_target.WithSrcs(map[string][]string{"_srcs": {"app"}}))
+	target.SetOriginalPath(pkg)
+	target.SetFqn("project", "path/to/pkg")
+
+	cm := MockNewCacheManager(t, WithProjectRoot(project))
+	ci := &CacheItem{target: target, Mappings: &CacheItemMappings{}}
+	hashes, err := cm.MapTargetSrcs(ci)
+	assert.NilError(t, err)
+
+	assert.DeepEqual(t, ci.Mappings.Srcs, map[string]map[string]string{
+		"_srcs": {
+			"app/index.js":    filepath.Join(pkg, "app/index.js"),
+			"app/lib/util.js": filepath.Join(pkg, "app/lib/util.js"),
+		},
+	})
+
+	// a new file in the directory changes the hashes
+	assert.NilError(t, os.WriteFile(filepath.Join(pkg, "app", "new.js"), []byte("new"), 0644))
+	changed, err := cm.MapTargetSrcs(&CacheItem{target: target, Mappings: &CacheItemMappings{}})
+	assert.NilError(t, err)
+	assert.Equal(t, len(changed["_srcs"]), len(hashes["_srcs"])+1)
+}
+
+func TestDirSrcsFollowLinkedFolders(t *testing.T) {
+	pkg := t.TempDir()
+	for name, content := range map[string]string{
+		"app/index.js":        "index",
+		"shared/lib.js":       "lib",
+		"shared/debug.log":    "log",
+		"shared/deep/util.js": "util",
+	} {
+		path := filepath.Join(pkg, name)
+		assert.NilError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NilError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	assert.NilError(t, os.Symlink("../shared", filepath.Join(pkg, "app", "lib")))
+	assert.NilError(t, os.WriteFile(filepath.Join(pkg, IgnoreFile), []byte("app/lib/*.log\n"), 0644))
+
+	cm := MockNewCacheManager(t)
+	files, err := cm.expandDirSrc(pkg, filepath.Join(pkg, "app"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, files, map[string]string{
+		"index.js":         filepath.Join(pkg, "app/index.js"),
+		"lib/lib.js":       filepath.Join(pkg, "app/lib/lib.js"),
+		"lib/deep/util.js": filepath.Join(pkg, "app/lib/deep/util.js"),
+	})
+
+	// a link to a folder containing it never ends
+	assert.NilError(t, os.Symlink("..", filepath.Join(pkg, "shared", "deep", "up")))
+	_, err = cm.expandDirSrc(pkg, filepath.Join(pkg, "app"))
+	assert.ErrorContains(t, err, "which contains it")
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package cache
+
+import "os"
+
+// without inodes, the index relies on size and modification time
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -4,13 +4,15 @@ import (
 	"archive/tar"
 	"crypto/sha256"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/bmatcuk/doublestar/v4"
 	"github.com/klauspost/compress/zstd"
 	"github.com/zen-io/zen-core/target"
 	"github.com/zen-io/zen-core/utils"
@@ -30,9 +32,15 @@ type CacheItem struct {
 	OutDest           string
 	Save              func() error
 	Restore           func() error
+	Delete            func() error
 	CheckOutputsExist func() (bool, error)
 
 	Mappings *CacheItemMappings
+
+	inputs      *HashInputs
+	buildStart  time.Time
+	link        LinkStrategy
+	deleteFiles func() error
 }
 
 func (ci *CacheItem) BuildCachePath() string {
@@ -51,10 +59,18 @@ func (ci *CacheItem) BuildOutPath() string {
 	}
 }
 
-// Requires cache to be computed
+// CheckCacheHits tells if the current hash was built before. A hit also marks the entry as
+// accessed, so the gc keeps it. Requires cache to be computed
 func (ci *CacheItem) CheckCacheHits() bool {
-	_, err := os.Stat(ci.MetadataPath)
-	return err == nil
+	if _, err := os.Stat(ci.MetadataPath); err != nil {
+		return false
+	}
+
+	// a failed touch only makes the entry look older to the gc
+	if err := ci.Touch(); err != nil {
+		ci.target.Warnln("marking cache entry as accessed: %s", err)
+	}
+	return true
 }
 
 func (ci *CacheItem) CheckDeployed() bool {
@@ -66,7 +82,12 @@ func (ci *CacheItem) SaveMetadata() error {
 		return fmt.Errorf("creating metadata folder: %w", err)
 	}
 
-	if data, err := json.Marshal(map[string]string{}); err != nil {
+	md, err := ci.buildMetadata()
+	if err != nil {
+		return err
+	}
+
+	if data, err := json.MarshalIndent(md, "", "  "); err != nil {
 		return err
 	} else if err := os.WriteFile(ci.MetadataPath, data, 0644); err != nil {
 		return err
@@ -75,36 +96,43 @@ func (ci *CacheItem) SaveMetadata() error {
 	return nil
 }
 
+// DeleteCache removes the stored artifacts and the cache folders of every hash of this target.
+// The packages nested under the target keep their cache.
 func (ci *CacheItem) DeleteCache() error {
-	if err := os.RemoveAll(filepath.Dir(ci.MetadataPath)); err != nil {
-		return fmt.Errorf("clean metadata: %w", err)
-	}
-
-	if !ci.target.External {
-		if err := os.RemoveAll(ci.BuildCachePath()); err != nil {
-			return fmt.Errorf("clean build: %w", err)
+	if ci.Delete != nil {
+		if err := ci.Delete(); err != nil {
+			return fmt.Errorf("clean artifact: %w", err)
 		}
 	}
 
-	if err := os.RemoveAll(ci.BuildOutPath()); err != nil {
-		return fmt.Errorf("clean outs: %w", err)
+	if ci.deleteFiles != nil {
+		return ci.deleteFiles()
 	}
 
 	return nil
 }
 
+// VerifyOutputs checks that the declared outs exist in the build cache. Globs are matched with
+// doublestar, and a glob that matches no files is an error, since it is most likely a typo.
 func (ci *CacheItem) VerifyOutputs(outs []string) (bool, error) {
+	root := ci.BuildCachePath()
+
 	for _, out := range outs {
 		if strings.Contains(out, "*") {
-			if err := filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
-				return filepath.SkipAll
-			}); errors.Is(err, filepath.SkipAll) {
-				continue
-			} else {
-				return false, err
+			matches, err := doublestar.Glob(os.DirFS(root), out, doublestar.WithFilesOnly())
+			if err != nil {
+				return false, fmt.Errorf("matching output %s: %w", out, err)
+			} else if len(matches) == 0 {
+				return false, fmt.Errorf("output %s did not match any file", out)
 			}
 		} else {
-			if _, err := os.Stat(out); os.IsNotExist(err) {
+			// outs can already be expanded into absolute paths
+			path := out
+			if !filepath.IsAbs(out) {
+				path = filepath.Join(root, out)
+			}
+
+			if _, err := os.Stat(path); os.IsNotExist(err) {
 				return false, nil
 			} else if err != nil {
 				return false, fmt.Errorf("error checking output %s: %w", out, err)
@@ -115,18 +143,88 @@ func (ci *CacheItem) VerifyOutputs(outs []string) (bool, error) {
 	return true, nil
 }
 
+// UndeclaredOutputs lists the files the build left in the build cache that are neither srcs
+// nor matched by the declared outs, relative to the build cache
+func (ci *CacheItem) UndeclaredOutputs(outs []string) ([]string, error) {
+	root := ci.BuildCachePath()
+
+	srcs := map[string]bool{}
+	for _, srcMap := range ci.Mappings.Srcs {
+		for name := range srcMap {
+			srcs[filepath.ToSlash(filepath.Clean(name))] = true
+		}
+	}
+
+	declared := func(rel string) bool {
+		for _, out := range outs {
+			if filepath.IsAbs(out) {
+				var err error
+				if out, err = filepath.Rel(root, out); err != nil {
+					continue
+				}
+			}
+			out = filepath.ToSlash(filepath.Clean(out))
+			if strings.Contains(out, "*") {
+				if ok, _ := doublestar.Match(out, rel); ok {
+					return true
+				}
+			} else if rel == out || strings.HasPrefix(rel, out+"/") {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	undeclared := []string{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if !srcs[rel] && !declared(rel) {
+			undeclared = append(undeclared, rel)
+		}
+
+		return nil
+	})
+
+	return undeclared, err
+}
+
+// outsideStrategy is the link strategy for files that enter or leave the cache. Hardlinks would
+// share the inode with package files and make them read only, so they are reflinked instead.
+func (ci *CacheItem) outsideStrategy() LinkStrategy {
+	if ci.link == LinkHardlink {
+		return LinkReflink
+	}
+
+	return ci.link
+}
+
+// CopySrcsToCache places the srcs into the build cache
 func (ci *CacheItem) CopySrcsToCache() error {
 	if ci.BaseBuildCache == "" || ci.target.External {
 		return nil
 	}
 
+	strategy := ci.outsideStrategy()
+
 	for _, srcMap := range ci.Mappings.Srcs {
 		for srcName, srcPath := range srcMap {
 			from := filepath.Join(srcPath)
 			to := filepath.Join(ci.BuildCachePath(), srcName)
 
 			ci.target.Traceln("into cache: from \"%s\" to \"%s\"", from, to)
-			if err := utils.Copy(from, to); err != nil {
+			if err := linkPath(from, to, strategy, false); err != nil {
 				return fmt.Errorf("copying src to cache: %w", err)
 			}
 		}
@@ -135,7 +233,28 @@ func (ci *CacheItem) CopySrcsToCache() error {
 	return nil
 }
 
+// outMarker is kept next to the metadata of a target, and holds the hash whose outs are in
+// its out folder
+const outMarker = ".out"
+
+func (ci *CacheItem) outMarkerPath() string {
+	return filepath.Join(filepath.Dir(ci.MetadataPath), outMarker)
+}
+
+// markOut records that the out folder holds the outs of the current hash
+func (ci *CacheItem) markOut() error {
+	if err := os.MkdirAll(filepath.Dir(ci.MetadataPath), os.ModePerm); err != nil {
+		return fmt.Errorf("creating metadata folder: %w", err)
+	}
+
+	return os.WriteFile(ci.outMarkerPath(), []byte(ci.Hash), 0644)
+}
+
 func (ci *CacheItem) CopyOutsIntoOut() error {
+	if err := os.Remove(ci.outMarkerPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing out marker: %w", err)
+	}
+
 	if err := os.RemoveAll(ci.BuildOutPath()); err != nil {
 		return fmt.Errorf("removing preexisting out dir: %w", err)
 	}
@@ -159,7 +278,7 @@ func (ci *CacheItem) CopyOutsIntoOut() error {
 		}
 
 		ci.target.Traceln("Out from %s to %s", from, to)
-		if err := utils.Copy(from, to); err != nil {
+		if err := linkPath(from, to, ci.link, true); err != nil {
 			if os.IsNotExist(err) {
 				return fmt.Errorf("out %s does not exist: %w", from, err)
 			} else {
@@ -168,7 +287,7 @@ func (ci *CacheItem) CopyOutsIntoOut() error {
 		}
 	}
 
-	return nil
+	return ci.markOut()
 }
 
 func (ci *CacheItem) ExportOutsToPath() error {
@@ -176,7 +295,7 @@ func (ci *CacheItem) ExportOutsToPath() error {
 		from := filepath.Join(ci.BuildOutPath(), toBase)
 		to := filepath.Join(ci.target.Path(), toBase)
 
-		if err := utils.Copy(from, to); err != nil {
+		if err := linkPath(from, to, ci.outsideStrategy(), false); err != nil {
 			if os.IsNotExist(err) {
 				return fmt.Errorf("out %s does not exist", toBase)
 			} else {
@@ -235,38 +354,55 @@ func (ci *CacheItem) ExpandSrcs() error {
 	return nil
 }
 
-func (ci *CacheItem) CalculateTargetBuildHash(srcHashes map[string]map[string]string) error {
-	shaHash := sha256.New()
-
-	if _, err := shaHash.Write([]byte(fmt.Sprint(srcHashes))); err != nil {
-		return err
+// CalculateTargetBuildHash hashes everything that defines the target build: its srcs, outs, env, labels,
+// the declaring block, scripts, tools, toolchains and the hashes of all its build deps
+func (ci *CacheItem) CalculateTargetBuildHash(srcHashes map[string]map[string]string, def *TargetDefinition, toolchains map[string]string) error {
+	if def == nil {
+		def = &TargetDefinition{}
 	}
 
-	if _, err := shaHash.Write([]byte(fmt.Sprint(ci.target.Outs))); err != nil {
-		return err
+	environments := make([]string, 0, len(ci.target.Environments))
+	for e := range ci.target.Environments {
+		environments = append(environments, e)
 	}
+	sort.Strings(environments)
 
-	if _, err := shaHash.Write([]byte(fmt.Sprint(ci.target.Env))); err != nil {
-		return err
+	scripts := map[string][]string{}
+	for name, script := range ci.target.Scripts {
+		if script == nil {
+			continue
+		}
+		scripts[name] = append(append([]string{}, script.Deps...), script.Alias...)
 	}
 
-	for _, label := range ci.target.Labels {
-		if _, err := shaHash.Write([]byte(label)); err != nil {
-			return err
-		}
+	ci.inputs = &HashInputs{
+		Type:         def.Type,
+		Definition:   def.Digest,
+		Srcs:         srcHashes,
+		Outs:         ci.target.Outs,
+		Env:          ci.target.Env,
+		Labels:       ci.target.Labels,
+		Environments: environments,
+		Scripts:      scripts,
+		Tools:        ci.target.Tools,
+		Toolchains:   toolchains,
+		Deps:         def.DepHashes,
 	}
 
-	for e := range ci.target.Environments {
-		if _, err := shaHash.Write([]byte(e)); err != nil {
-			return err
-		}
+	// json sorts map keys, so the encoding is stable
+	data, err := json.Marshal(ci.inputs)
+	if err != nil {
+		return err
 	}
 
-	ci.Hash = fmt.Sprintf("%x", shaHash.Sum(nil))
+	ci.Hash = fmt.Sprintf("%x", sha256.Sum256(data))
 
 	return nil
 }
 
+// Compress archives the out dir, keeping directories, symlinks, modes and modification times.
+// Entries are written in lexical order without owner information, so the same outputs
+// always produce the same archive bytes.
 func (ci *CacheItem) Compress(out string) error {
 	// create the output file
 	outFile, err := os.Create(out)
@@ -276,48 +412,21 @@ func (ci *CacheItem) Compress(out string) error {
 	defer outFile.Close()
 
 	// create the zstd writer
-	zstdWriter, err := zstd.NewWriter(outFile)
+	zstdWriter, err := zstd.NewWriter(outFile, zstd.WithEncoderConcurrency(1))
 	if err != nil {
 		return err
 	}
 
 	// create a tar writer to write multiple files to the zstd writer
 	tarWriter := tar.NewWriter(zstdWriter)
-	defer tarWriter.Close()
 
-	// walk through the source directory and add all files to the tar writer
-	err = filepath.Walk(ci.OutDest, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.Mode().IsRegular() {
-			return nil
-		}
-		relPath, err := filepath.Rel(ci.OutDest, path)
-		if err != nil {
-			return err
-		}
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-		header := &tar.Header{
-			Name: relPath,
-			Mode: int64(info.Mode()),
-			Size: info.Size(),
-		}
-		err = tarWriter.WriteHeader(header)
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(tarWriter, file)
-		if err != nil {
-			return err
-		}
-		return nil
-	})
-	if err != nil {
+	// walk through the source directory, in lexical order, and add every entry to the tar writer
+	if err := archiveDir(tarWriter, ci.OutDest); err != nil {
+		return err
+	}
+
+	// write the tar footer before flushing the zstd frame
+	if err := tarWriter.Close(); err != nil {
 		return err
 	}
 
@@ -326,9 +435,11 @@ func (ci *CacheItem) Compress(out string) error {
 		return err
 	}
 
-	return nil
+	return outFile.Close()
 }
 
+// Decompress extracts an archive created by Compress into the out dir. Entries that would
+// land outside of it are rejected, since archives can come from shared caches.
 func (ci *CacheItem) Decompress(src string) error {
 	// open the compressed file
 	inFile, err := os.Open(src)
@@ -342,46 +453,7 @@ func (ci *CacheItem) Decompress(src string) error {
 	if err != nil {
 		return err
 	}
+	defer zstdReader.Close()
 
-	// create a tar reader to read multiple files from the zstd reader
-	tarReader := tar.NewReader(zstdReader)
-
-	// extract each file from the tar reader
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		relPath := header.Name
-		absPath := filepath.Join(ci.OutDest, relPath)
-		if header.FileInfo().IsDir() {
-			err = os.MkdirAll(absPath, header.FileInfo().Mode())
-			if err != nil {
-				return err
-			}
-		} else {
-			file, err := os.Create(absPath)
-			if err != nil {
-				return err
-			}
-
-			defer file.Close()
-			_, err = io.Copy(file, tarReader)
-			if err != nil {
-				return err
-			}
-			err = file.Chmod(header.FileInfo().Mode())
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	// close the zstd reader
-	zstdReader.Close()
-
-	return nil
+	return extractTar(tar.NewReader(zstdReader), ci.OutDest)
 }
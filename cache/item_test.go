@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zen-io/zen-core/mock"
+	zen_target "github.com/zen-io/zen-core/target"
+	"gotest.tools/v3/assert"
+)
+
+func mockBuiltItem(t *testing.T, files ...string) *CacheItem {
+	ci := &CacheItem{
+		target:         mock.MockBasicTarget(t),
+		Hash:           "hash",
+		BaseBuildCache: t.TempDir(),
+		Mappings: &CacheItemMappings{
+			Srcs: map[string]map[string]string{"_srcs": {"main.go": "/pkg/main.go"}},
+		},
+	}
+
+	for _, f := range append(files, "main.go") {
+		path := filepath.Join(ci.BuildCachePath(), f)
+		assert.NilError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NilError(t, os.WriteFile(path, []byte(f), 0644))
+	}
+
+	return ci
+}
+
+func TestVerifyOutputsMatchesGlobs(t *testing.T) {
+	ci := mockBuiltItem(t, "dist/js/app.js", "dist/css/app.css", "bin/tool")
+
+	ok, err := ci.VerifyOutputs([]string{"dist/**/*.js", "bin/tool"})
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+
+	ok, err = ci.VerifyOutputs([]string{"bin/missing"})
+	assert.NilError(t, err)
+	assert.Assert(t, !ok)
+
+	ok, err = ci.VerifyOutputs([]string{filepath.Join(ci.BuildCachePath(), "bin/tool")})
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+
+	_, err = ci.VerifyOutputs([]string{"dist/**/*.map"})
+	assert.ErrorContains(t, err, "output dist/**/*.map did not match any file")
+}
+
+func TestUndeclaredOutputs(t *testing.T) {
+	ci := mockBuiltItem(t, "dist/js/app.js", "dist/css/app.css", "bin/tool", "bin/lib/helper", "build.log")
+
+	undeclared, err := ci.UndeclaredOutputs([]string{"dist/**/*.js", filepath.Join(ci.BuildCachePath(), "bin")})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, undeclared, []string{"build.log", "dist/css/app.css"})
+}
+
+func TestUndeclaredOutputsSkipsDepOuts(t *testing.T) {
+	cm := MockNewCacheManager(t)
+
+	dep := zen_target.NewTarget("dep", zen_target.WithOuts([]string{"lib.txt"}))
+	dep.SetOriginalPath(t.TempDir())
+	dep.SetFqn("project", "pkg")
+	assert.NilError(t, dep.EnsureValidTarget())
+	depCi, err := cm.LoadTargetCache(dep, nil)
+	assert.NilError(t, err)
+	assert.NilError(t, depCi.ExpandOuts(dep.Outs))
+
+	target := zen_target.NewTarget("app", zen_target.WithSrcs(map[string][]string{"_srcs": {"//project/pkg:dep"}}), zen_target.WithOuts([]string{"app"}))
+	target.SetOriginalPath(t.TempDir())
+	target.SetFqn("project", "pkg")
+	target.Scripts["build"].Deps = []string{"//project/pkg:dep"}
+	assert.NilError(t, target.EnsureValidTarget())
+	ci, err := cm.LoadTargetCache(target, nil)
+	assert.NilError(t, err)
+
+	// the build folder holds the outs of the dep, copied as srcs, and the declared out
+	for _, f := range []string{"lib.txt", "app"} {
+		assert.NilError(t, os.MkdirAll(ci.BuildCachePath(), os.ModePerm))
+		assert.NilError(t, os.WriteFile(filepath.Join(ci.BuildCachePath(), f), []byte(f), 0644))
+	}
+
+	undeclared, err := ci.UndeclaredOutputs(target.Outs)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, undeclared, []string{})
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/zen-io/zen-core/utils"
+)
+
+// LinkStrategy decides how files are placed into the build cache, the out dir and the package
+type LinkStrategy string
+
+const (
+	// LinkCopy does a full byte copy
+	LinkCopy LinkStrategy = "copy"
+	// LinkHardlink shares the inode between the build folder and the out dir, and makes the
+	// shared file read only, so cached outputs are immutable and cannot be written through.
+	// It is only used within the cache: srcs and outs exported to the package are reflinked
+	// instead, so files the user edits are never shared or made read only. Falls back to a
+	// copy across filesystems.
+	LinkHardlink LinkStrategy = "hardlink"
+	// LinkReflink clones the file with copy on write, where the filesystem supports it,
+	// and falls back to a copy otherwise
+	LinkReflink LinkStrategy = "reflink"
+)
+
+func parseLinkStrategy(s *string) (LinkStrategy, error) {
+	if s == nil || *s == "" {
+		return LinkCopy, nil
+	}
+
+	switch strategy := LinkStrategy(*s); strategy {
+	case LinkCopy, LinkHardlink, LinkReflink:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown link strategy %s. known strategies are: %s, %s, %s", *s, LinkCopy, LinkHardlink, LinkReflink)
+	}
+}
+
+// linkPath places from at to with the given strategy, recursing into directories. Symlinks are
+// followed, like a plain copy does, unless keepLinks is set. Then they are recreated as they are,
+// which is what outs need, since they are archived with their links.
+func linkPath(from, to string, strategy LinkStrategy, keepLinks bool) error {
+	stat := os.Stat
+	if keepLinks {
+		stat = os.Lstat
+	}
+
+	info, err := stat(from)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return linkEntry(from, to, info, strategy)
+	}
+
+	if err := os.MkdirAll(to, os.ModePerm); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := linkPath(filepath.Join(from, e.Name()), filepath.Join(to, e.Name()), strategy, keepLinks); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func linkEntry(from, to string, info fs.FileInfo, strategy LinkStrategy) error {
+	if err := os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+		return fmt.Errorf("creating dest dir %s: %w", filepath.Dir(to), err)
+	}
+
+	// the destination might be a read only link from a previous run, so it is never written through
+	if err := os.Remove(to); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing %s: %w", to, err)
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(from)
+		if err != nil {
+			return err
+		}
+
+		return os.Symlink(link, to)
+	}
+
+	switch strategy {
+	case LinkHardlink:
+		if err := os.Link(from, to); err == nil {
+			return os.Chmod(to, info.Mode().Perm()&^0222)
+		}
+	case LinkReflink:
+		if err := reflink(from, to); err == nil {
+			return os.Chmod(to, info.Mode().Perm())
+		}
+		os.Remove(to)
+	}
+
+	return utils.CopyFile(from, to)
+}
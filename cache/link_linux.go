@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"os"
+	"syscall"
+)
+
+// FICLONE from linux/fs.h
+const ficlone = 0x40049409
+
+// reflink clones from into a new file at to, sharing the data blocks until either is written
+func reflink(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dest, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dest.Fd(), ficlone, src.Fd()); errno != 0 {
+		return errno
+	}
+
+	return dest.Close()
+}
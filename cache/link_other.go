@@ -0,0 +1,9 @@
+//go:build !linux
+
+package cache
+
+import "errors"
+
+func reflink(from, to string) error {
+	return errors.New("reflinks are only supported on linux")
+}
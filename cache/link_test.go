@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zen-io/zen-core/mock"
+	"gotest.tools/v3/assert"
+)
+
+func mockLinkSrc(t *testing.T) string {
+	src := filepath.Join(t.TempDir(), "src")
+	assert.NilError(t, os.MkdirAll(filepath.Join(src, "bin"), os.ModePerm))
+	assert.NilError(t, os.WriteFile(filepath.Join(src, "bin", "tool"), []byte("tool"), 0755))
+	assert.NilError(t, os.Symlink("bin/tool", filepath.Join(src, "tool")))
+
+	return src
+}
+
+func TestLinkPathHardlinkIsReadOnly(t *testing.T) {
+	src := mockLinkSrc(t)
+	dest := filepath.Join(t.TempDir(), "dest")
+	assert.NilError(t, linkPath(src, dest, LinkHardlink, true))
+
+	srcInfo, err := os.Stat(filepath.Join(src, "bin", "tool"))
+	assert.NilError(t, err)
+	destInfo, err := os.Stat(filepath.Join(dest, "bin", "tool"))
+	assert.NilError(t, err)
+
+	assert.Assert(t, os.SameFile(srcInfo, destInfo))
+	assert.Equal(t, destInfo.Mode().Perm(), os.FileMode(0555))
+
+	link, err := os.Readlink(filepath.Join(dest, "tool"))
+	assert.NilError(t, err)
+	assert.Equal(t, link, "bin/tool")
+
+	// linking again replaces the read only file instead of writing through it
+	assert.NilError(t, linkPath(src, dest, LinkCopy, true))
+}
+
+func TestLinkPathReflinkFallsBackToCopy(t *testing.T) {
+	src := mockLinkSrc(t)
+	dest := filepath.Join(t.TempDir(), "dest")
+	assert.NilError(t, linkPath(src, dest, LinkReflink, true))
+
+	content, err := os.ReadFile(filepath.Join(dest, "bin", "tool"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "tool")
+
+	srcInfo, err := os.Stat(filepath.Join(src, "bin", "tool"))
+	assert.NilError(t, err)
+	destInfo, err := os.Stat(filepath.Join(dest, "bin", "tool"))
+	assert.NilError(t, err)
+
+	assert.Assert(t, !os.SameFile(srcInfo, destInfo))
+	assert.Equal(t, destInfo.Mode().Perm(), os.FileMode(0755))
+}
+
+func TestParseLinkStrategy(t *testing.T) {
+	strategy, err := parseLinkStrategy(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, strategy, LinkCopy)
+
+	unknown := "symlink"
+	_, err = parseLinkStrategy(&unknown)
+	assert.ErrorContains(t, err, "unknown link strategy symlink")
+}
+
+func TestLinkPathFollowsLinks(t *testing.T) {
+	src := mockLinkSrc(t)
+	dest := filepath.Join(t.TempDir(), "dest")
+	assert.NilError(t, linkPath(filepath.Join(src, "tool"), dest, LinkCopy, false))
+
+	info, err := os.Lstat(dest)
+	assert.NilError(t, err)
+	assert.Assert(t, info.Mode().IsRegular())
+
+	// links inside folders are followed as well
+	destDir := filepath.Join(t.TempDir(), "dest")
+	assert.NilError(t, linkPath(src, destDir, LinkCopy, false))
+	content, err := os.ReadFile(filepath.Join(destDir, "tool"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "tool")
+
+	info, err = os.Lstat(filepath.Join(destDir, "tool"))
+	assert.NilError(t, err)
+	assert.Assert(t, info.Mode().IsRegular())
+}
+
+func TestExportOutsDoesNotHardlink(t *testing.T) {
+	out := mockLinkSrc(t)
+	target := mock.MockBasicTarget(t)
+	ci := &CacheItem{target: target, OutDest: out, link: LinkHardlink, Mappings: &CacheItemMappings{Outs: map[string]string{"bin/tool": "bin/tool"}}}
+	assert.NilError(t, ci.ExportOutsToPath())
+
+	outInfo, err := os.Stat(filepath.Join(out, "bin", "tool"))
+	assert.NilError(t, err)
+	exported, err := os.Stat(filepath.Join(target.Path(), "bin", "tool"))
+	assert.NilError(t, err)
+
+	assert.Assert(t, !os.SameFile(outInfo, exported))
+	assert.Equal(t, exported.Mode().Perm(), os.FileMode(0755))
+	assert.Equal(t, outInfo.Mode().Perm(), os.FileMode(0755))
+}
@@ -1,44 +1,79 @@
 package cache
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/zen-io/zen-core/utils"
 )
 
 func NewLocalCache() *LocalCache {
 	return &LocalCache{}
 }
 
+// LocalCache stores one compressed archive per cache key under a root directory
 type LocalCache struct {
-	outpath string
+	root string
 }
 
 func (lc *LocalCache) Load(cfg map[string]string) error {
-	lc.outpath = cfg["out"]
+	lc.root = cfg["artifacts"]
+	if lc.root == "" {
+		return fmt.Errorf("local cache needs an artifacts path")
+	}
+
 	return nil
 }
 
+func (lc *LocalCache) artifactPath(key string) string {
+	return filepath.Join(lc.root, key+".tar.zst")
+}
+
 func (lc *LocalCache) Save(key, fpath string) func() error {
 	return func() error {
+		dest := lc.artifactPath(key)
+		if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+			return fmt.Errorf("creating artifact folder: %w", err)
+		}
+
+		// a hard link is free when tmp and artifacts share a filesystem
+		os.Remove(dest)
+		if err := os.Link(fpath, dest); err == nil {
+			return nil
+		}
+
+		if err := utils.CopyFile(fpath, dest); err != nil {
+			return fmt.Errorf("storing artifact: %w", err)
+		}
+
 		return nil
 	}
 }
 
-func (lc *LocalCache) Restore(key string) func() error {
+func (lc *LocalCache) Restore(key, fpath string) func() error {
 	return func() error {
+		if err := utils.CopyFile(lc.artifactPath(key), fpath); err != nil {
+			return fmt.Errorf("fetching artifact: %w", err)
+		}
+
 		return nil
 	}
 }
 
 func (lc *LocalCache) Delete(key string) func() error {
 	return func() error {
+		if err := os.Remove(lc.artifactPath(key)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("deleting artifact: %w", err)
+		}
+
 		return nil
 	}
 }
 
 func (lc *LocalCache) CheckOutputsExist(key string) func() (bool, error) {
 	return func() (bool, error) {
-		if _, err := os.Stat(filepath.Join(lc.outpath, key)); err != nil {
+		if _, err := os.Stat(lc.artifactPath(key)); err != nil {
 			if os.IsNotExist(err) {
 				return false, nil
 			} else {
@@ -49,3 +84,14 @@ func (lc *LocalCache) CheckOutputsExist(key string) func() (bool, error) {
 		return true, nil
 	}
 }
+
+func (lc *LocalCache) ArtifactSize(key string) (int64, int64, error) {
+	info, err := os.Stat(lc.artifactPath(key))
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	return info.Size(), info.Size(), nil
+}
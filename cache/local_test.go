@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zen-io/zen-core/mock"
+	"gotest.tools/v3/assert"
+)
+
+func TestLocalCacheSaveRestore(t *testing.T) {
+	cache := MockNewCacheManager(t)
+	target := mock.MockBasicTarget(t)
+
+	srcMappings := mock.MockSrcs["basic"].ExpandSrcsMappings(target.Path())
+	mock.CreateFiles(t, mock.FileMapToSlice(t, srcMappings))
+
+	ci, err := cache.LoadTargetCache(target, nil)
+	assert.NilError(t, err)
+
+	exists, err := ci.CheckOutputsExist()
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+
+	outFile := filepath.Join(ci.BuildOutPath(), "nested", "hello1")
+	assert.NilError(t, os.MkdirAll(filepath.Dir(outFile), os.ModePerm))
+	assert.NilError(t, os.WriteFile(outFile, []byte("hello"), 0755))
+
+	assert.NilError(t, ci.Save())
+	exists, err = ci.CheckOutputsExist()
+	assert.NilError(t, err)
+	assert.Assert(t, exists)
+
+	assert.NilError(t, os.RemoveAll(ci.BuildOutPath()))
+	assert.NilError(t, ci.Restore())
+
+	content, err := os.ReadFile(outFile)
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "hello")
+
+	info, err := os.Stat(outFile)
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0755))
+
+	assert.NilError(t, ci.DeleteCache())
+	exists, err = ci.CheckOutputsExist()
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+}
@@ -1,9 +1,13 @@
 package cache
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 
 	zen_target "github.com/zen-io/zen-core/target"
 	"github.com/zen-io/zen-core/utils"
@@ -11,44 +15,167 @@ import (
 	atomics "github.com/tiagoposse/go-sync-types"
 )
 
+// CacheIO moves output archives in and out of a cache backend.
+// Save must leave fpath in place, since it can be handed to several backends.
 type CacheIO interface {
 	Save(key, fpath string) func() error
-	Restore(key string) func() error
+	Restore(key, fpath string) func() error
 	Delete(key string) func() error
 	CheckOutputsExist(key string) func() (bool, error)
 }
 
 type CacheConfig struct {
-	Tmp      *string           `hcl:"tmp"`
-	Metadata *string           `hcl:"metadata"`
-	Out      *string           `hcl:"out"`
-	Exec     *string           `hcl:"logs"`
-	Type     *string           `hcl:"type"`
-	Config   map[string]string `hcl:"config"`
+	Tmp       *string            `hcl:"tmp"`
+	Metadata  *string            `hcl:"metadata"`
+	Out       *string            `hcl:"out"`
+	Exec      *string            `hcl:"logs"`
+	Artifacts *string            `hcl:"artifacts"`
+	Type      *string            `hcl:"type"`
+	Config    map[string]string  `hcl:"config"`
+	Tiers     []*CacheTierConfig `hcl:"tier,block" mapstructure:"tier"`
+	MaxSize   *string            `hcl:"max_size" mapstructure:"max_size"`
+	MaxAge    *string            `hcl:"max_age" mapstructure:"max_age"`
+	KeepLast  *int               `hcl:"keep_last" mapstructure:"keep_last"`
+	AutoGC    *bool              `hcl:"auto_gc" mapstructure:"auto_gc"`
+	Link      *string            `hcl:"link" mapstructure:"link"`
 }
 
 type CacheManager struct {
 	config *CacheConfig
 	io     CacheIO
+	link   LinkStrategy
 	items  *atomics.Map[string, *CacheItem] //map[string]*CacheItem
+
+	hashes      *FileHashIndex
+	forceRehash bool
+	hashWorkers int
+
+	projectRoot       string
+	projectIgnore     *ignoreRules
+	projectIgnoreErr  error
+	projectIgnoreOnce sync.Once
+	pkgIgnores        *atomics.Map[string, *ignoreRules]
+
+	toolchains       map[string]string
+	toolchainDigests map[string]string
+	toolchainsOnce   sync.Once
+}
+
+type CacheManagerOption func(cm *CacheManager)
+
+// WithToolchains includes the project toolchains in every target hash
+func WithToolchains(toolchains map[string]string) CacheManagerOption {
+	return func(cm *CacheManager) {
+		cm.toolchains = toolchains
+	}
+}
+
+// WithForceRehash ignores the file hash index, hashing every src again
+func WithForceRehash(force bool) CacheManagerOption {
+	return func(cm *CacheManager) {
+		cm.forceRehash = force
+	}
+}
+
+// WithHashWorkers bounds the number of files hashed at the same time. 1 hashes sequentially.
+func WithHashWorkers(workers int) CacheManagerOption {
+	return func(cm *CacheManager) {
+		cm.hashWorkers = workers
+	}
+}
+
+// WithProjectRoot reads the project ignore file, applied to every directory src
+func WithProjectRoot(root string) CacheManagerOption {
+	return func(cm *CacheManager) {
+		cm.projectRoot = root
+	}
 }
 
-func NewCacheManager(config *CacheConfig) *CacheManager {
+func NewCacheManager(config *CacheConfig, opts ...CacheManagerOption) (*CacheManager, error) {
 	cm := &CacheManager{
-		config: config,
-		items:  atomics.NewMap[string, *CacheItem](),
+		config:      config,
+		items:       atomics.NewMap[string, *CacheItem](),
+		pkgIgnores:  atomics.NewMap[string, *ignoreRules](),
+		hashWorkers: runtime.NumCPU(),
+	}
+
+	for _, opt := range opts {
+		opt(cm)
+	}
+
+	indexPath := ""
+	if config.Metadata != nil {
+		indexPath = filepath.Join(*config.Metadata, "files.idx")
+	}
+	cm.hashes = NewFileHashIndex(indexPath, cm.forceRehash)
+
+	link, err := parseLinkStrategy(config.Link)
+	if err != nil {
+		return nil, err
+	}
+	cm.link = link
+
+	backendCfg := map[string]string{}
+	if config.Artifacts != nil {
+		backendCfg["artifacts"] = *config.Artifacts
 	}
 
-	// type defaults to local
-	if *config.Type == "local" {
-		cm.io = &LocalCache{
-			outpath: *config.Out,
+	if len(config.Tiers) == 0 {
+		io, err := newBackend(backendType(config.Type), utils.MergeMaps(backendCfg, config.Config))
+		if err != nil {
+			return nil, err
 		}
+		cm.io = io
+
+		return cm, nil
+	}
+
+	tiers := make([]*CacheTier, 0, len(config.Tiers))
+	for i, tierCfg := range config.Tiers {
+		if tierCfg.ReadOnly && tierCfg.WriteOnly {
+			return nil, fmt.Errorf("cache tier %d cannot be read only and write only", i)
+		}
+
+		name := backendType(tierCfg.Type)
+		io, err := newBackend(name, utils.MergeMaps(backendCfg, tierCfg.Config))
+		if err != nil {
+			return nil, fmt.Errorf("cache tier %d: %w", i, err)
+		}
+
+		tiers = append(tiers, &CacheTier{
+			Name:      fmt.Sprintf("%d (%s)", i, name),
+			IO:        io,
+			ReadOnly:  tierCfg.ReadOnly,
+			WriteOnly: tierCfg.WriteOnly,
+			Evict:     i == 0 || tierCfg.Evict,
+		})
+	}
+	cm.io = NewTieredCache(tiers...)
+
+	return cm, nil
+}
+
+// localIO is the backend of the local cache, the first tier when there are several
+func (cm *CacheManager) localIO() CacheIO {
+	if tc, ok := cm.io.(*TieredCache); ok {
+		return tc.Local()
 	}
-	return cm
+
+	return cm.io
 }
 
-func (cm *CacheManager) LoadTargetCache(target *zen_target.Target) (*CacheItem, error) {
+// type defaults to local
+func backendType(t *string) string {
+	if t == nil || *t == "" {
+		return "local"
+	}
+
+	return *t
+}
+
+// LoadTargetCache maps the target srcs and calculates its hash. def can be nil when the
+// target was not declared in a package file.
+func (cm *CacheManager) LoadTargetCache(target *zen_target.Target, def *TargetDefinition) (*CacheItem, error) {
 	buildStepFqn := fmt.Sprintf("%s:build", target.Qn())
 	if val, ok := cm.items.Get(buildStepFqn); ok {
 		return val, nil
@@ -56,6 +183,7 @@ func (cm *CacheManager) LoadTargetCache(target *zen_target.Target) (*CacheItem,
 
 	cacheItem := &CacheItem{
 		target: target,
+		link:   cm.link,
 		Mappings: &CacheItemMappings{
 			Srcs: make(map[string]map[string]string),
 			Outs: make(map[string]string),
@@ -72,7 +200,7 @@ func (cm *CacheManager) LoadTargetCache(target *zen_target.Target) (*CacheItem,
 		return nil, fmt.Errorf("mapping srcs: %w", err)
 	}
 
-	if err := cacheItem.CalculateTargetBuildHash(srcHashes); err != nil {
+	if err := cacheItem.CalculateTargetBuildHash(srcHashes, def, cm.ToolchainDigests()); err != nil {
 		return nil, fmt.Errorf("calculating hash: %w", err)
 	}
 
@@ -82,14 +210,260 @@ func (cm *CacheManager) LoadTargetCache(target *zen_target.Target) (*CacheItem,
 
 	cacheKey := filepath.Join(target.Package(), target.Name, cacheItem.Hash)
 	cacheItem.MetadataPath = filepath.Join(*cm.config.Metadata, cacheKey+".json")
-	cacheItem.Save = cm.io.Save(cacheKey, filepath.Join(*cm.config.Tmp, cacheKey+".tar"))
-	cacheItem.Restore = cm.io.Restore(cacheKey)
+	archivePath := filepath.Join(*cm.config.Tmp, cacheKey+".tar.zst")
+	cacheItem.Save = cm.saveArchive(cacheItem, cacheKey, archivePath)
+	cacheItem.Restore = cm.restoreArchive(cacheItem, cacheKey, archivePath)
+	cacheItem.Delete = cm.deleteTargetArtifacts(cacheItem)
+	cacheItem.deleteFiles = cm.deleteTargetFiles(cacheItem)
 	cacheItem.CheckOutputsExist = cm.io.CheckOutputsExist(cacheKey)
 	cm.items.Put(buildStepFqn, cacheItem)
 
 	return cacheItem, nil
 }
 
+// saveArchive compresses the target outs and hands the archive over to the cache backend
+func (cm *CacheManager) saveArchive(ci *CacheItem, key, archivePath string) func() error {
+	save := cm.io.Save(key, archivePath)
+
+	return func() error {
+		if ci.OutDest == "" {
+			return nil
+		}
+
+		if err := os.MkdirAll(filepath.Dir(archivePath), os.ModePerm); err != nil {
+			return fmt.Errorf("creating archive folder: %w", err)
+		}
+
+		// a stale archive might be linked into a backend, so never write through it
+		os.Remove(archivePath)
+		defer os.Remove(archivePath)
+
+		if err := ci.Compress(archivePath); err != nil {
+			return fmt.Errorf("compressing outs: %w", err)
+		}
+
+		if err := save(); err != nil {
+			return fmt.Errorf("saving archive: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// restoreArchive fetches the archive from the cache backend and extracts it into the out dir
+func (cm *CacheManager) restoreArchive(ci *CacheItem, key, archivePath string) func() error {
+	restore := cm.io.Restore(key, archivePath)
+
+	return func() error {
+		if ci.OutDest == "" {
+			return nil
+		}
+
+		if err := os.MkdirAll(filepath.Dir(archivePath), os.ModePerm); err != nil {
+			return fmt.Errorf("creating archive folder: %w", err)
+		}
+
+		// a stale archive might be linked into a backend, so never write through it
+		os.Remove(archivePath)
+		defer os.Remove(archivePath)
+
+		if err := restore(); err != nil {
+			return fmt.Errorf("restoring archive: %w", err)
+		}
+
+		if err := os.Remove(ci.outMarkerPath()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing out marker: %w", err)
+		}
+
+		if err := os.RemoveAll(ci.OutDest); err != nil {
+			return fmt.Errorf("removing preexisting out dir: %w", err)
+		}
+
+		if err := ci.Decompress(archivePath); err != nil {
+			return fmt.Errorf("decompressing outs: %w", err)
+		}
+
+		return ci.markOut()
+	}
+}
+
+// deleteTargetArtifacts deletes the artifacts of the current hash and of every hash found in the target metadata
+func (cm *CacheManager) deleteTargetArtifacts(ci *CacheItem) func() error {
+	return func() error {
+		return cm.deleteArtifacts(ci.target.Package(), ci.target.Name, ci.Hash)
+	}
+}
+
+// deleteTargetFiles deletes the cache folders of the current hash and of every hash found in the
+// target metadata, along with the outs of the current hash
+func (cm *CacheManager) deleteTargetFiles(ci *CacheItem) func() error {
+	return func() error {
+		outs := []string{}
+		for out := range ci.Mappings.Outs {
+			outs = append(outs, out)
+		}
+
+		paths, err := cm.targetCachePaths(ci.target.Package(), ci.target.Name, outs...)
+		if err != nil {
+			return err
+		}
+
+		return cm.deletePaths(ci.target.Package(), ci.target.Name, paths)
+	}
+}
+
+func (cm *CacheManager) deleteArtifacts(pkg, name string, hashes ...string) error {
+	keys, err := cm.TargetArtifactKeys(pkg, name, hashes...)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := cm.io.Delete(k)(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TargetArtifactKeys returns the keys of the artifacts of the hashes passed and of every hash
+// found in the target metadata
+func (cm *CacheManager) TargetArtifactKeys(pkg, name string, hashes ...string) ([]string, error) {
+	history, err := cm.TargetMetadataHistory(pkg, name)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+	for _, md := range history {
+		hashes = append(hashes, md.Hash)
+	}
+
+	keys := []string{}
+	seen := map[string]bool{"": true}
+	for _, h := range hashes {
+		if !seen[h] {
+			seen[h] = true
+			keys = append(keys, filepath.Join(pkg, name, h))
+		}
+	}
+
+	return keys, nil
+}
+
+// TargetCachePaths returns the files and folders that hold the cache of a target, for every
+// hash: metadata, build folders and outs. The folders of a target also hold the ones of the
+// packages nested under it, so only the entries named after a hash and the outs recorded in the
+// metadata are returned. They only depend on the target name, so the target does not need to
+// be hashed, nor its deps loaded.
+func (cm *CacheManager) TargetCachePaths(pkg, name string) ([]string, error) {
+	return cm.targetCachePaths(pkg, name)
+}
+
+// targetCachePaths also returns the outs passed, relative to the out folder of the target
+func (cm *CacheManager) targetCachePaths(pkg, name string, outs ...string) ([]string, error) {
+	paths := []string{}
+	for _, root := range []string{*cm.config.Metadata, *cm.config.Tmp} {
+		dir := filepath.Join(root, pkg, name)
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		// <hash>.json, <hash> and <hash>.tar.zst, or the out marker
+		for _, e := range entries {
+			if isDigest(strings.SplitN(e.Name(), ".", 2)[0]) || e.Name() == outMarker {
+				paths = append(paths, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+
+	history, err := cm.TargetMetadataHistory(pkg, name)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+
+	for _, md := range history {
+		for _, o := range md.Outputs {
+			outs = append(outs, o.Path)
+		}
+	}
+
+	outDir := filepath.Join(*cm.config.Out, pkg, name)
+	seen := map[string]bool{}
+	for _, o := range outs {
+		out := filepath.Join(outDir, o)
+		if seen[out] {
+			continue
+		}
+		seen[out] = true
+
+		if _, err := os.Lstat(out); err == nil {
+			paths = append(paths, out)
+		}
+	}
+
+	return paths, nil
+}
+
+// DeleteTargetCache removes the stored artifacts of every hash found in the target metadata,
+// and what TargetCachePaths returns
+func (cm *CacheManager) DeleteTargetCache(pkg, name string) error {
+	paths, err := cm.TargetCachePaths(pkg, name)
+	if err != nil {
+		return err
+	}
+
+	if err := cm.deleteArtifacts(pkg, name); err != nil {
+		return fmt.Errorf("clean artifact: %w", err)
+	}
+
+	return cm.deletePaths(pkg, name, paths)
+}
+
+// deletePaths removes paths, and then the folders of the target they leave empty
+func (cm *CacheManager) deletePaths(pkg, name string, paths []string) error {
+	roots := map[string]bool{}
+	for _, root := range []string{*cm.config.Metadata, *cm.config.Tmp, *cm.config.Out} {
+		roots[filepath.Dir(filepath.Join(root, pkg, name))] = true
+	}
+
+	for _, p := range paths {
+		if err := os.RemoveAll(p); err != nil {
+			return fmt.Errorf("clean %s: %w", p, err)
+		}
+
+		// removing a folder that is not empty fails, and so do the ones above it
+		for dir := filepath.Dir(p); !roots[dir] && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// isDigest tells if s is a hex encoded sha256, like target hashes and blob digests
+func isDigest(s string) bool {
+	if len(s) != 2*sha256.Size {
+		return false
+	}
+
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (cm *CacheManager) SaveFileHashes() error {
+	return cm.hashes.Save()
+}
+
 func (cm *CacheManager) TargetHash(qn string) (string, error) {
 	ci, ok := cm.items.Get(qn)
 	if !ok {
@@ -103,6 +477,7 @@ func (cm *CacheManager) TargetHash(qn string) (string, error) {
 	return ci.Hash, nil
 }
 
+// TargetOuts maps the outs of a step, relative to its out folder, to their paths
 func (cm *CacheManager) TargetOuts(stepQn string) (map[string]string, error) {
 	ci, ok := cm.items.Get(stepQn)
 	if !ok {
@@ -111,15 +486,28 @@ func (cm *CacheManager) TargetOuts(stepQn string) (map[string]string, error) {
 
 	m := map[string]string{}
 	for _, o := range ci.target.Outs {
-		m[strings.TrimPrefix(o, ci.BuildOutPath())] = o
+		rel := strings.TrimPrefix(o, ci.BuildOutPath())
+		m[strings.TrimPrefix(rel, string(filepath.Separator))] = o
 	}
 
 	return m, nil
 }
 
+// srcHashJob is a file src waiting to be hashed into hashes[category][key]
+type srcHashJob struct {
+	category string
+	key      string
+	path     string
+	hash     string
+}
+
+// MapTargetSrcs maps every src of the target to its path, and returns their hashes. Directory
+// srcs are expanded into the files they contain. Files are hashed by a bounded pool of
+// workers, and the result does not depend on their order.
 func (cm *CacheManager) MapTargetSrcs(ci *CacheItem) (map[string]map[string]string, error) {
 	mappings := make(map[string]map[string]string)
 	hashes := make(map[string]map[string]string)
+	jobs := []*srcHashJob{}
 
 	for srcCategory, sSrcs := range ci.target.Srcs {
 		mappings[srcCategory] = map[string]string{}
@@ -147,29 +535,80 @@ func (cm *CacheManager) MapTargetSrcs(ci *CacheItem) (map[string]map[string]stri
 				} else {
 					mappings[srcCategory] = utils.MergeMaps(mappings[srcCategory], m)
 					for k, v := range m {
-						h, err := utils.FileHash(v)
-						if err != nil {
-							return nil, fmt.Errorf("glob file hash %s, %w", v, err)
-						} else {
-							hashes[srcCategory][k] = h
-						}
+						jobs = append(jobs, &srcHashJob{category: srcCategory, key: k, path: v})
 					}
 				}
 			} else {
 				fullpath := utils.AbsoluteFilePath(ci.target.Path(), src)
-				mappings[srcCategory][src] = fullpath
-				h, err := utils.FileHash(fullpath)
-				if err != nil {
-					return nil, fmt.Errorf("abs file hash %s, %w", fullpath, err)
-				} else {
-					hashes[srcCategory][src] = h
+				if info, err := os.Stat(fullpath); err == nil && info.IsDir() {
+					files, err := cm.expandDirSrc(ci.target.Path(), fullpath)
+					if err != nil {
+						return nil, fmt.Errorf("expanding dir %s, %w", src, err)
+					}
+
+					for rel, p := range files {
+						key := filepath.Join(src, rel)
+						mappings[srcCategory][key] = p
+						jobs = append(jobs, &srcHashJob{category: srcCategory, key: key, path: p})
+					}
+					continue
 				}
+
+				mappings[srcCategory][src] = fullpath
+				jobs = append(jobs, &srcHashJob{category: srcCategory, key: src, path: fullpath})
 			}
 		}
 
 	}
 
+	if err := cm.hashSrcs(jobs); err != nil {
+		return nil, err
+	}
+
+	for _, job := range jobs {
+		hashes[job.category][job.key] = job.hash
+	}
+
 	ci.Mappings.Srcs = mappings
 
 	return hashes, nil
 }
+
+// hashSrcs fills the hash of every job. When several fail, the error of the first job is returned.
+func (cm *CacheManager) hashSrcs(jobs []*srcHashJob) error {
+	workers := cm.hashWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	errs := make([]error, len(jobs))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				jobs[i].hash, errs[i] = cm.hashes.Hash(jobs[i].path)
+			}
+		}()
+	}
+
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("file hash %s, %w", jobs[i].path, err)
+		}
+	}
+
+	return nil
+}
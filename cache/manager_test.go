@@ -1,36 +1,66 @@
 package cache
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 
 	"github.com/zen-io/zen-core/mock"
+	zen_target "github.com/zen-io/zen-core/target"
 	"github.com/zen-io/zen-core/utils"
 	"gotest.tools/v3/assert"
 )
 
-func MockNewCacheManager(t *testing.T) *CacheManager {
+func MockNewCacheManager(t testing.TB, opts ...CacheManagerOption) *CacheManager {
 	root := t.TempDir()
 
-	return NewCacheManager(&CacheConfig{
-		Tmp:      utils.StringPtr(filepath.Join(root, "tmp")),
-		Metadata: utils.StringPtr(filepath.Join(root, "metadata")),
-		Out:      utils.StringPtr(filepath.Join(root, "out")),
-		Exec:     utils.StringPtr(filepath.Join(root, "exec")),
-	})
+	cm, err := NewCacheManager(&CacheConfig{
+		Tmp:       utils.StringPtr(filepath.Join(root, "tmp")),
+		Metadata:  utils.StringPtr(filepath.Join(root, "metadata")),
+		Out:       utils.StringPtr(filepath.Join(root, "out")),
+		Exec:      utils.StringPtr(filepath.Join(root, "exec")),
+		Artifacts: utils.StringPtr(filepath.Join(root, "artifacts")),
+		Type:      utils.StringPtr("local"),
+	}, opts...)
+	assert.NilError(t, err)
+
+	return cm
+}
+
+// MockBuiltCacheItem loads the cache of a basic target as its build leaves it: with hello1 and
+// bye1 in its out folder, and its metadata saved
+func MockBuiltCacheItem(t *testing.T, cm *CacheManager) *CacheItem {
+	target := mock.MockBasicTarget(t)
+	assert.NilError(t, target.EnsureValidTarget())
+	mock.CreateFiles(t, mock.FileMapToSlice(t, mock.MockSrcs["basic"].ExpandSrcsMappings(target.Path())))
+
+	ci, err := cm.LoadTargetCache(target, nil)
+	assert.NilError(t, err)
+	assert.NilError(t, ci.ExpandOuts(target.Outs))
+
+	assert.NilError(t, os.MkdirAll(ci.BuildOutPath(), os.ModePerm))
+	assert.NilError(t, os.WriteFile(filepath.Join(ci.BuildOutPath(), "hello1"), []byte("hello"), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(ci.BuildOutPath(), "bye1"), []byte("bye"), 0644))
+	assert.NilError(t, ci.SaveMetadata())
+
+	return ci
 }
 
 func TestLoadTargetCacheSimple(t *testing.T) {
 	cache := MockNewCacheManager(t)
 	target := mock.MockBasicTarget(t)
+	assert.NilError(t, target.EnsureValidTarget())
 
-	pkgPath := strings.ReplaceAll(target.Qn(), ":", "/")
+	// the cache folders are per project, so they only use the package and the name
+	pkgPath := filepath.Join(target.Package(), target.Name)
 
 	srcMappings := mock.MockSrcs["basic"].ExpandSrcsMappings(target.Path())
 	mock.CreateFiles(t, mock.FileMapToSlice(t, srcMappings))
 
-	ci, err := cache.LoadTargetCache(target)
+	ci, err := cache.LoadTargetCache(target, nil)
 	assert.NilError(t, err)
 	assert.Equal(t, ci.BuildCachePath(), filepath.Join(*cache.config.Tmp, pkgPath, ci.Hash))
 	assert.Equal(t, ci.BuildOutPath(), filepath.Join(*cache.config.Out, pkgPath))
@@ -55,3 +85,174 @@ func TestLoadTargetCacheSimple(t *testing.T) {
 
 // 	complex = mock.MockComplextTarget(t)
 // }
+
+func TestTargetHashCoversDefinition(t *testing.T) {
+	hashWith := func(def *TargetDefinition, toolchains map[string]string) string {
+		target := mock.MockBasicTarget(t)
+		srcMappings := mock.MockSrcs["basic"].ExpandSrcsMappings(target.Path())
+		mock.CreateFiles(t, mock.FileMapToSlice(t, srcMappings))
+
+		cm := MockNewCacheManager(t)
+		cm.toolchains = toolchains
+
+		ci, err := cm.LoadTargetCache(target, def)
+		assert.NilError(t, err)
+		return ci.Hash
+	}
+
+	base := hashWith(&TargetDefinition{Type: "sh_script", Digest: "a"}, nil)
+	assert.Equal(t, base, hashWith(&TargetDefinition{Type: "sh_script", Digest: "a"}, nil))
+	assert.Assert(t, base != hashWith(&TargetDefinition{Type: "sh_script", Digest: "b"}, nil))
+	assert.Assert(t, base != hashWith(&TargetDefinition{Type: "filegroup", Digest: "a"}, nil))
+	assert.Assert(t, base != hashWith(&TargetDefinition{Type: "sh_script", Digest: "a", DepHashes: map[string]string{"//project/dep:dep:build": "1"}}, nil))
+	assert.Assert(t, base != hashWith(&TargetDefinition{Type: "sh_script", Digest: "a"}, map[string]string{"golang": "/usr/bin/go"}))
+}
+
+func TestToolchainDigestIgnoresPath(t *testing.T) {
+	content := []byte("#!/bin/sh")
+	first := filepath.Join(t.TempDir(), "go")
+	second := filepath.Join(t.TempDir(), "go")
+	assert.NilError(t, os.WriteFile(first, content, 0755))
+	assert.NilError(t, os.WriteFile(second, content, 0755))
+
+	assert.Equal(t, toolchainDigest(first), toolchainDigest(second))
+
+	assert.NilError(t, os.WriteFile(second, []byte("#!/bin/bash"), 0755))
+	assert.Assert(t, toolchainDigest(first) != toolchainDigest(second))
+}
+
+func TestDeleteCacheKeepsOtherTargets(t *testing.T) {
+	cache := MockNewCacheManager(t)
+
+	items := []*CacheItem{}
+	for _, name := range []string{"one", "two"} {
+		target := zen_target.NewTarget(name, zen_target.WithSrcs(mock.MockSrcs["basic"].Srcs))
+		target.SetOriginalPath(t.TempDir())
+		target.SetFqn("project", "path/to/pkg")
+		mock.CreateFiles(t, mock.FileMapToSlice(t, mock.MockSrcs["basic"].ExpandSrcsMappings(target.Path())))
+
+		ci, err := cache.LoadTargetCache(target, nil)
+		assert.NilError(t, err)
+		assert.NilError(t, os.MkdirAll(ci.BuildOutPath(), os.ModePerm))
+		assert.NilError(t, os.WriteFile(filepath.Join(ci.BuildOutPath(), "out"), []byte(name), 0644))
+		assert.NilError(t, ci.SaveMetadata())
+		assert.NilError(t, ci.Save())
+		items = append(items, ci)
+	}
+
+	assert.NilError(t, cache.DeleteTargetCache("path/to/pkg", "one"))
+	assert.Assert(t, !items[0].CheckCacheHits())
+	exists, err := items[0].CheckOutputsExist()
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+
+	assert.Assert(t, items[1].CheckCacheHits())
+	exists, err = items[1].CheckOutputsExist()
+	assert.NilError(t, err)
+	assert.Assert(t, exists)
+	_, err = os.Stat(filepath.Join(items[1].BuildOutPath(), "out"))
+	assert.NilError(t, err)
+}
+
+func TestDeleteTargetCacheKeepsNestedPackages(t *testing.T) {
+	cache := MockNewCacheManager(t)
+
+	// the folders of //project/path/to/pkg:one also hold the ones of //project/path/to/pkg/one:nested
+	items := []*CacheItem{}
+	for pkg, name := range map[string]string{"path/to/pkg": "one", "path/to/pkg/one": "nested"} {
+		target := zen_target.NewTarget(name, zen_target.WithSrcs(mock.MockSrcs["basic"].Srcs), zen_target.WithOuts([]string{"out"}))
+		target.SetOriginalPath(t.TempDir())
+		target.SetFqn("project", pkg)
+		assert.NilError(t, target.EnsureValidTarget())
+		mock.CreateFiles(t, mock.FileMapToSlice(t, mock.MockSrcs["basic"].ExpandSrcsMappings(target.Path())))
+
+		ci, err := cache.LoadTargetCache(target, nil)
+		assert.NilError(t, err)
+		assert.NilError(t, ci.ExpandOuts(target.Outs))
+		assert.NilError(t, os.MkdirAll(ci.BuildCachePath(), os.ModePerm))
+		assert.NilError(t, os.MkdirAll(ci.BuildOutPath(), os.ModePerm))
+		assert.NilError(t, os.WriteFile(filepath.Join(ci.BuildOutPath(), "out"), []byte(name), 0644))
+		assert.NilError(t, ci.SaveMetadata())
+		assert.NilError(t, ci.Save())
+		items = append(items, ci)
+	}
+	one, nested := items[0], items[1]
+	if one.target.Name != "one" {
+		one, nested = nested, one
+	}
+
+	keys, err := cache.TargetArtifactKeys("path/to/pkg", "one")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, keys, []string{filepath.Join("path/to/pkg/one", one.Hash)})
+
+	paths, err := cache.TargetCachePaths("path/to/pkg", "one")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, paths, []string{one.MetadataPath, one.BuildCachePath(), filepath.Join(one.BuildOutPath(), "out")})
+
+	assert.NilError(t, cache.DeleteTargetCache("path/to/pkg", "one"))
+	assert.Assert(t, !one.CheckCacheHits())
+	_, err = os.Stat(filepath.Join(one.BuildOutPath(), "out"))
+	assert.Assert(t, os.IsNotExist(err))
+
+	assert.Assert(t, nested.CheckCacheHits())
+	exists, err := nested.CheckOutputsExist()
+	assert.NilError(t, err)
+	assert.Assert(t, exists)
+	for _, p := range []string{nested.BuildCachePath(), filepath.Join(nested.BuildOutPath(), "out")} {
+		_, err = os.Stat(p)
+		assert.NilError(t, err)
+	}
+
+	// same for the cache item
+	assert.NilError(t, one.SaveMetadata())
+	assert.NilError(t, one.DeleteCache())
+	assert.Assert(t, !one.CheckCacheHits())
+	assert.Assert(t, nested.CheckCacheHits())
+}
+
+func mockManySrcsTarget(t testing.TB, files int) *zen_target.Target {
+	target := zen_target.NewTarget("many", zen_target.WithSrcs(map[string][]string{
+		"_srcs": {"assets/*", "main.go"},
+	}))
+	target.SetOriginalPath(t.TempDir())
+	target.SetFqn("project", "path/to/pkg")
+
+	assert.NilError(t, os.MkdirAll(filepath.Join(target.Path(), "assets"), os.ModePerm))
+	assert.NilError(t, os.WriteFile(filepath.Join(target.Path(), "main.go"), []byte("package main"), 0644))
+	for i := 0; i < files; i++ {
+		content := strings.Repeat(fmt.Sprintf("asset %d\n", i), 1024)
+		assert.NilError(t, os.WriteFile(filepath.Join(target.Path(), "assets", fmt.Sprintf("%d.txt", i)), []byte(content), 0644))
+	}
+
+	return target
+}
+
+func TestMapTargetSrcsParallelIsDeterministic(t *testing.T) {
+	target := mockManySrcsTarget(t, 200)
+
+	sequential, err := MockNewCacheManager(t, WithHashWorkers(1)).MapTargetSrcs(&CacheItem{target: target, Mappings: &CacheItemMappings{}})
+	assert.NilError(t, err)
+	assert.Equal(t, len(sequential["_srcs"]), 201)
+
+	parallel, err := MockNewCacheManager(t, WithHashWorkers(8)).MapTargetSrcs(&CacheItem{target: target, Mappings: &CacheItemMappings{}})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, parallel, sequential)
+}
+
+func BenchmarkMapTargetSrcs(b *testing.B) {
+	target := mockManySrcsTarget(b, 2000)
+
+	for _, mode := range []struct {
+		name    string
+		workers int
+	}{{"sequential", 1}, {"parallel", runtime.NumCPU()}} {
+		b.Run(mode.name, func(b *testing.B) {
+			cm := MockNewCacheManager(b, WithHashWorkers(mode.workers), WithForceRehash(true))
+			for i := 0; i < b.N; i++ {
+				if _, err := cm.MapTargetSrcs(&CacheItem{target: target, Mappings: &CacheItemMappings{}}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
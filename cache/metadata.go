@@ -0,0 +1,228 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zen-io/zen-core/utils"
+)
+
+// EngineVersion is recorded in the metadata of every build. It is meant to be set at link time
+// with -ldflags "-X github.com/zen-io/zen-engine/cache.EngineVersion=..."
+var EngineVersion = "dev"
+
+// HashInputs are the values that went into a target build hash
+type HashInputs struct {
+	Type         string                       `json:"type"`
+	Definition   string                       `json:"definition"`
+	Srcs         map[string]map[string]string `json:"srcs"`
+	Outs         []string                     `json:"outs"`
+	Env          map[string]string            `json:"env"`
+	Labels       []string                     `json:"labels"`
+	Environments []string                     `json:"environments"`
+	Scripts      map[string][]string          `json:"scripts"`
+	Tools        map[string]string            `json:"tools"`
+	Toolchains   map[string]string            `json:"toolchains"`
+	Deps         map[string]string            `json:"deps"`
+}
+
+// TargetDefinition is what defines a target besides its own fields
+type TargetDefinition struct {
+	Type      string            // block type that declared the target
+	Digest    string            // digest of the block that declared the target
+	DepHashes map[string]string // hashes of every build dep, by fqn
+}
+
+type OutputMetadata struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Digest string `json:"digest"`
+}
+
+// CacheMetadata is stored for every successful build, keyed by the target build hash
+type CacheMetadata struct {
+	Fqn           string            `json:"fqn"`
+	Hash          string            `json:"hash"`
+	Inputs        *HashInputs       `json:"inputs"`
+	Outputs       []*OutputMetadata `json:"outputs"`
+	CreatedAt     time.Time         `json:"created_at"`
+	BuildDuration time.Duration     `json:"build_duration"`
+	EngineVersion string            `json:"engine_version"`
+}
+
+// StartBuildTimer marks the start of the build, so its duration can be stored in the metadata
+func (ci *CacheItem) StartBuildTimer() {
+	ci.buildStart = time.Now()
+}
+
+// Inputs returns the values used to calculate the build hash
+func (ci *CacheItem) Inputs() *HashInputs {
+	return ci.inputs
+}
+
+func (ci *CacheItem) buildMetadata() (*CacheMetadata, error) {
+	md := &CacheMetadata{
+		Fqn:           ci.target.Qn(),
+		Hash:          ci.Hash,
+		Inputs:        ci.inputs,
+		Outputs:       []*OutputMetadata{},
+		CreatedAt:     time.Now().UTC(),
+		EngineVersion: EngineVersion,
+	}
+
+	if !ci.buildStart.IsZero() {
+		md.BuildDuration = time.Since(ci.buildStart)
+	}
+
+	for _, out := range ci.target.Outs {
+		if err := filepath.WalkDir(out, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			} else if !d.Type().IsRegular() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			digest, err := utils.FileHash(path)
+			if err != nil {
+				return fmt.Errorf("hashing output %s: %w", path, err)
+			}
+
+			md.Outputs = append(md.Outputs, &OutputMetadata{
+				Path:   strings.TrimPrefix(path, ci.BuildOutPath()+"/"),
+				Size:   info.Size(),
+				Digest: digest,
+			})
+
+			return nil
+		}); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("collecting outputs: %w", err)
+		}
+	}
+
+	sort.Slice(md.Outputs, func(i, j int) bool {
+		return md.Outputs[i].Path < md.Outputs[j].Path
+	})
+
+	return md, nil
+}
+
+// ReadMetadata reads the metadata stored for this cache item hash
+func (ci *CacheItem) ReadMetadata() (*CacheMetadata, error) {
+	return ReadMetadataFile(ci.MetadataPath)
+}
+
+func ReadMetadataFile(path string) (*CacheMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	md := &CacheMetadata{}
+	if err := json.Unmarshal(data, md); err != nil {
+		return nil, fmt.Errorf("unmarshalling metadata %s: %w", path, err)
+	}
+
+	return md, nil
+}
+
+// TargetMetadata returns the metadata of the current hash for a loaded target
+func (cm *CacheManager) TargetMetadata(stepQn string) (*CacheMetadata, error) {
+	ci, ok := cm.items.Get(stepQn)
+	if !ok {
+		return nil, fmt.Errorf("%s not in cache", stepQn)
+	}
+
+	return ci.ReadMetadata()
+}
+
+// TargetMetadataHistory returns the metadata of every stored build of a target, newest first
+func (cm *CacheManager) TargetMetadataHistory(pkg, name string) ([]*CacheMetadata, error) {
+	entries, err := os.ReadDir(filepath.Join(*cm.config.Metadata, pkg, name))
+	if os.IsNotExist(err) {
+		return []*CacheMetadata{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	history := []*CacheMetadata{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+
+		md, err := ReadMetadataFile(filepath.Join(*cm.config.Metadata, pkg, name, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, md)
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].CreatedAt.After(history[j].CreatedAt)
+	})
+
+	return history, nil
+}
+
+// EstimatedBuildDuration averages the durations of the latest builds of a target. It returns
+// false when the target was never built.
+func (cm *CacheManager) EstimatedBuildDuration(pkg, name string) (time.Duration, bool) {
+	history, err := cm.TargetMetadataHistory(pkg, name)
+	if err != nil {
+		return 0, false
+	}
+
+	var total time.Duration
+	count := 0
+	for _, md := range history {
+		if md.BuildDuration <= 0 {
+			continue
+		}
+
+		total += md.BuildDuration
+		if count++; count == 5 {
+			break
+		}
+	}
+
+	if count == 0 {
+		return 0, false
+	}
+
+	return total / time.Duration(count), true
+}
+
+// WalkMetadata calls fn for every metadata file stored in the project cache
+func (cm *CacheManager) WalkMetadata(fn func(path string, md *CacheMetadata) error) error {
+	err := filepath.WalkDir(*cm.config.Metadata, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		md, err := ReadMetadataFile(path)
+		if err != nil {
+			return err
+		}
+
+		return fn(path, md)
+	})
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
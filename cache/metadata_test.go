@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestSaveMetadata(t *testing.T) {
+	cache := MockNewCacheManager(t)
+	ci := MockBuiltCacheItem(t, cache)
+	target := ci.target
+
+	md, err := cache.TargetMetadata(target.Qn() + ":build")
+	assert.NilError(t, err)
+	assert.Equal(t, md.Fqn, target.Qn())
+	assert.Equal(t, md.Hash, ci.Hash)
+	assert.Equal(t, md.EngineVersion, EngineVersion)
+	assert.DeepEqual(t, md.Inputs, ci.Inputs())
+	assert.DeepEqual(t, md.Outputs, []*OutputMetadata{
+		{Path: "bye1", Size: 3, Digest: "b49f425a7e1f9cff3856329ada223f2f9d368f15a00cf48df16ca95986137fe8"},
+		{Path: "hello1", Size: 5, Digest: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+	})
+
+	history, err := cache.TargetMetadataHistory(target.Package(), target.Name)
+	assert.NilError(t, err)
+	assert.Equal(t, len(history), 1)
+	assert.Equal(t, history[0].Hash, ci.Hash)
+}
+
+func TestEstimatedBuildDuration(t *testing.T) {
+	cache := MockNewCacheManager(t)
+
+	_, ok := cache.EstimatedBuildDuration("pkg", "target")
+	assert.Assert(t, !ok)
+
+	for i, d := range []time.Duration{0, 2 * time.Second, 4 * time.Second} {
+		md := &CacheMetadata{Hash: fmt.Sprint(i), BuildDuration: d, CreatedAt: time.Now().Add(time.Duration(i) * time.Minute)}
+		data, err := json.Marshal(md)
+		assert.NilError(t, err)
+
+		path := filepath.Join(*cache.config.Metadata, "pkg", "target", md.Hash+".json")
+		assert.NilError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NilError(t, os.WriteFile(path, data, 0644))
+	}
+
+	// builds without a recorded duration are left out
+	estimate, ok := cache.EstimatedBuildDuration("pkg", "target")
+	assert.Assert(t, ok)
+	assert.Equal(t, estimate, 3*time.Second)
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CacheTierConfig configures one backend of a tiered cache. Tiers are checked in the
+// order they are declared, so faster tiers should come first. The first tier is the local
+// one: gc, clean and fsck only remove entries from it, and from the tiers with evict set.
+// Other tiers are usually shared, and are left to be maintained on their own.
+type CacheTierConfig struct {
+	Type      *string           `hcl:"type"`
+	Config    map[string]string `hcl:"config"`
+	ReadOnly  bool              `hcl:"read_only" mapstructure:"read_only"`
+	WriteOnly bool              `hcl:"write_only" mapstructure:"write_only"`
+	Evict     bool              `hcl:"evict"`
+}
+
+type CacheTier struct {
+	Name      string
+	IO        CacheIO
+	ReadOnly  bool
+	WriteOnly bool
+	Evict     bool
+}
+
+func NewTieredCache(tiers ...*CacheTier) *TieredCache {
+	return &TieredCache{tiers: tiers}
+}
+
+// TieredCache reads through its tiers in order, populating the faster tiers on a hit,
+// and writes back to every tier that is not read only
+type TieredCache struct {
+	tiers []*CacheTier
+}
+
+func (tc *TieredCache) Save(key, fpath string) func() error {
+	return func() error {
+		var errs []error
+		for _, tier := range tc.tiers {
+			if tier.ReadOnly {
+				continue
+			}
+
+			if err := tier.IO.Save(key, fpath)(); err != nil {
+				errs = append(errs, fmt.Errorf("tier %s: %w", tier.Name, err))
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+}
+
+func (tc *TieredCache) Restore(key, fpath string) func() error {
+	return func() error {
+		var errs []error
+		for i, tier := range tc.tiers {
+			if tier.WriteOnly {
+				continue
+			}
+
+			if exists, err := tier.IO.CheckOutputsExist(key)(); err != nil {
+				errs = append(errs, fmt.Errorf("tier %s: %w", tier.Name, err))
+				continue
+			} else if !exists {
+				continue
+			}
+
+			if err := tier.IO.Restore(key, fpath)(); err != nil {
+				errs = append(errs, fmt.Errorf("tier %s: %w", tier.Name, err))
+				continue
+			}
+
+			// populating the faster tiers is best effort, the outputs are already restored
+			for _, faster := range tc.tiers[:i] {
+				if !faster.ReadOnly {
+					faster.IO.Save(key, fpath)()
+				}
+			}
+
+			return nil
+		}
+
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+
+		return fmt.Errorf("%s not found in any cache tier", key)
+	}
+}
+
+// Local is the backend of the first tier, the one maintained locally
+func (tc *TieredCache) Local() CacheIO {
+	return tc.tiers[0].IO
+}
+
+// Delete removes the key from the tiers that are evicted from, so local maintenance never
+// removes entries from the shared tiers of everyone else
+func (tc *TieredCache) Delete(key string) func() error {
+	return func() error {
+		var errs []error
+		for _, tier := range tc.tiers {
+			if tier.ReadOnly || !tier.Evict {
+				continue
+			}
+
+			if err := tier.IO.Delete(key)(); err != nil {
+				errs = append(errs, fmt.Errorf("tier %s: %w", tier.Name, err))
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+}
+
+func (tc *TieredCache) CheckOutputsExist(key string) func() (bool, error) {
+	return func() (bool, error) {
+		var errs []error
+		for _, tier := range tc.tiers {
+			if tier.WriteOnly {
+				continue
+			}
+
+			if exists, err := tier.IO.CheckOutputsExist(key)(); err != nil {
+				errs = append(errs, fmt.Errorf("tier %s: %w", tier.Name, err))
+			} else if exists {
+				return true, nil
+			}
+		}
+
+		return false, errors.Join(errs...)
+	}
+}
+
+// Prune forwards to every evicted tier that shares data between keys
+func (tc *TieredCache) Prune() (int, int64, error) {
+	removed := 0
+	var freed int64
+	var errs []error
+
+	for _, tier := range tc.tiers {
+		if pruner, ok := tier.IO.(BlobPruner); ok && !tier.ReadOnly && tier.Evict {
+			r, f, err := pruner.Prune()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("tier %s: %w", tier.Name, err))
+			}
+			removed += r
+			freed += f
+		}
+	}
+
+	return removed, freed, errors.Join(errs...)
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zen-io/zen-core/utils"
+	"gotest.tools/v3/assert"
+)
+
+func TestTieredCache(t *testing.T) {
+	root := t.TempDir()
+	fast := filepath.Join(root, "fast")
+	shared := filepath.Join(root, "shared")
+	upload := filepath.Join(root, "upload")
+
+	cm, err := NewCacheManager(&CacheConfig{
+		Artifacts: utils.StringPtr(filepath.Join(root, "artifacts")),
+		Tiers: []*CacheTierConfig{
+			{Config: map[string]string{"artifacts": fast}},
+			{Type: utils.StringPtr("local"), Config: map[string]string{"artifacts": shared}, ReadOnly: true},
+			{Type: utils.StringPtr("local"), Config: map[string]string{"artifacts": upload}, WriteOnly: true},
+		},
+	})
+	assert.NilError(t, err)
+
+	archive := filepath.Join(root, "archive.tar.zst")
+	assert.NilError(t, os.WriteFile(archive, []byte("archive"), 0644))
+
+	// only the shared tier has the key
+	sharedIO := &LocalCache{root: shared}
+	assert.NilError(t, sharedIO.Save("pkg/name/hash", archive)())
+
+	exists, err := cm.io.CheckOutputsExist("pkg/name/hash")()
+	assert.NilError(t, err)
+	assert.Assert(t, exists)
+
+	restored := filepath.Join(root, "restored.tar.zst")
+	assert.NilError(t, cm.io.Restore("pkg/name/hash", restored)())
+	content, err := os.ReadFile(restored)
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "archive")
+
+	// the fast tier was populated on the hit
+	_, err = os.Stat(filepath.Join(fast, "pkg/name/hash.tar.zst"))
+	assert.NilError(t, err)
+
+	// writes skip the read only tier and reach the write only one
+	assert.NilError(t, cm.io.Save("pkg/name/other", archive)())
+	_, err = os.Stat(filepath.Join(shared, "pkg/name/other.tar.zst"))
+	assert.Assert(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(upload, "pkg/name/other.tar.zst"))
+	assert.NilError(t, err)
+
+	// the write only tier is never read
+	assert.NilError(t, os.Remove(filepath.Join(fast, "pkg/name/other.tar.zst")))
+	exists, err = cm.io.CheckOutputsExist("pkg/name/other")()
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+	assert.ErrorContains(t, cm.io.Restore("pkg/name/other", restored)(), "not found in any cache tier")
+}
+
+func TestTieredCacheDeleteOnlyEvictsLocalTiers(t *testing.T) {
+	root := t.TempDir()
+	tiers := []string{filepath.Join(root, "local"), filepath.Join(root, "shared"), filepath.Join(root, "evicted")}
+
+	cm, err := NewCacheManager(&CacheConfig{
+		Tiers: []*CacheTierConfig{
+			{Config: map[string]string{"artifacts": tiers[0]}},
+			{Config: map[string]string{"artifacts": tiers[1]}},
+			{Config: map[string]string{"artifacts": tiers[2]}, Evict: true},
+		},
+	})
+	assert.NilError(t, err)
+
+	archive := filepath.Join(root, "archive.tar.zst")
+	assert.NilError(t, os.WriteFile(archive, []byte("archive"), 0644))
+	assert.NilError(t, cm.io.Save("pkg/name/hash", archive)())
+	assert.NilError(t, cm.io.Delete("pkg/name/hash")())
+
+	_, err = os.Stat(filepath.Join(tiers[0], "pkg/name/hash.tar.zst"))
+	assert.Assert(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(tiers[1], "pkg/name/hash.tar.zst"))
+	assert.NilError(t, err)
+	_, err = os.Stat(filepath.Join(tiers[2], "pkg/name/hash.tar.zst"))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestTieredCacheInvalidTier(t *testing.T) {
+	_, err := NewCacheManager(&CacheConfig{
+		Tiers: []*CacheTierConfig{{ReadOnly: true, WriteOnly: true}},
+	})
+	assert.ErrorContains(t, err, "cannot be read only and write only")
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"os"
+	"os/exec"
+
+	zen_target "github.com/zen-io/zen-core/target"
+	"github.com/zen-io/zen-core/utils"
+)
+
+// ToolchainDigests resolves every project toolchain to a digest of its content, for binaries,
+// so upgrading a toolchain in place changes the target hashes. Resolved paths are machine
+// specific, so they are left out, and machines sharing a cache get the same hashes.
+// Digests are calculated once per run.
+func (cm *CacheManager) ToolchainDigests() map[string]string {
+	cm.toolchainsOnce.Do(func() {
+		cm.toolchainDigests = make(map[string]string, len(cm.toolchains))
+		for name, tc := range cm.toolchains {
+			cm.toolchainDigests[name] = toolchainDigest(tc)
+		}
+	})
+
+	return cm.toolchainDigests
+}
+
+func toolchainDigest(tc string) string {
+	// references are part of the build deps, and hashed as such
+	if zen_target.IsTargetReference(tc) {
+		return tc
+	}
+
+	path, err := exec.LookPath(tc)
+	if err != nil {
+		path = tc
+	}
+
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return tc
+	}
+
+	digest, err := utils.FileHash(path)
+	if err != nil {
+		return tc
+	}
+
+	return digest
+}
@@ -25,6 +25,9 @@ type BuildConfig struct {
 	Variables       map[string]string `hcl:"variables"`
 	SecretVariables map[string]string `hcl:"variables"`
 	Path            *string           `hcl:"path"` // additional PATH
+	Jobs            *int              `hcl:"jobs" mapstructure:"jobs"`
+	Memory          *string           `hcl:"memory" mapstructure:"memory"`       // memory available to steps, unlimited by default
+	Exclusive       map[string]int    `hcl:"exclusive" mapstructure:"exclusive"` // steps allowed at once per exclusive tag, 1 by default
 }
 
 type DeployConfig struct {
@@ -39,6 +39,7 @@ type ProjectBuildConfig struct {
 	Variables       map[string]string `mapstructure:"variables"`
 	PassEnv         []string          `mapstructure:"pass_env"`
 	PassSecretEnv   []string          `mapstructure:"pass_secret_env"`
+	Sandbox         *bool             `mapstructure:"sandbox"`
 	SecretVariables map[string]string // this is not passed via the file
 }
 
@@ -94,13 +95,13 @@ func LoadProjectConfig(configPath string, cliconfig *CliConfig) (*ProjectConfig,
 			Variables:    make(map[string]string),
 		},
 		Cache: &cache.CacheConfig{
-			Tmp:      StringPtr(filepath.Join(baseCacheRoot, "cache")),
-			Out:      StringPtr(filepath.Join(baseCacheRoot, "out")),
-			Metadata: StringPtr(filepath.Join(baseCacheRoot, "metadata")),
-			Exec:     StringPtr(filepath.Join(baseCacheRoot, "exec")),
-			Artifacts:  StringPtr(filepath.Join(baseCacheRoot, "artifacts")),
-			Type:     utils.StringPtr("local"),
-			Config:   map[string]string{},
+			Tmp:       StringPtr(filepath.Join(baseCacheRoot, "cache")),
+			Out:       StringPtr(filepath.Join(baseCacheRoot, "out")),
+			Metadata:  StringPtr(filepath.Join(baseCacheRoot, "metadata")),
+			Exec:      StringPtr(filepath.Join(baseCacheRoot, "exec")),
+			Artifacts: StringPtr(filepath.Join(baseCacheRoot, "artifacts")),
+			Type:      utils.StringPtr("local"),
+			Config:    map[string]string{},
 		},
 		Plugins:  []*ProjectPluginConfig{},
 		Commands: []*ProjectCommandConfig{},
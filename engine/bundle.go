@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"github.com/zen-io/zen-engine/cache"
+)
+
+// ExportCache writes the cache entries of the targets matching args, and of all their
+// transitive build deps, into a single bundle at bundlePath. Every target has to be built.
+func (eng *Engine) ExportCache(w io.Writer, bundlePath string, args []string) error {
+	ts, err := eng.ExpandTargets(args, "build")
+	if err != nil {
+		return fmt.Errorf("expanding targets: %w", err)
+	}
+
+	bw, err := cache.NewBundleWriter(bundlePath)
+	if err != nil {
+		return fmt.Errorf("creating bundle: %w", err)
+	}
+
+	exported := map[string]bool{}
+	for _, t := range ts {
+		fqn, err := zen_targets.NewFqnFromStr(t)
+		if err != nil {
+			bw.Abort()
+			return err
+		}
+
+		if err := eng.exportWithDeps(w, bw, fqn.BuildFqn(), exported); err != nil {
+			bw.Abort()
+			return err
+		}
+	}
+
+	if err := bw.Close(); err != nil {
+		return fmt.Errorf("writing bundle: %w", err)
+	}
+
+	_, err = fmt.Fprintf(w, "exported %d entries to %s\n", len(exported), bundlePath)
+	return err
+}
+
+func (eng *Engine) exportWithDeps(w io.Writer, bw *cache.BundleWriter, targetFqn string, exported map[string]bool) error {
+	if exported[targetFqn] {
+		return nil
+	}
+
+	ci, err := eng.loadCacheWithDeps(targetFqn)
+	if err != nil {
+		return fmt.Errorf("loading cache for %s: %w", targetFqn, err)
+	}
+
+	fqn, err := zen_targets.NewFqnFromStr(targetFqn)
+	if err != nil {
+		return err
+	}
+
+	ts, err := eng.ResolveTarget(fqn)
+	if err != nil {
+		return err
+	}
+
+	deps, err := eng.buildDeps(ts[0])
+	if err != nil {
+		return err
+	}
+
+	for _, dep := range deps {
+		if err := eng.exportWithDeps(w, bw, dep, exported); err != nil {
+			return err
+		}
+	}
+
+	if err := eng.Projects[fqn.Project()].Cache.ExportItem(bw, fqn.Project(), ci); err != nil {
+		return fmt.Errorf("exporting %s: %w", fqn.Qn(), err)
+	}
+	exported[targetFqn] = true
+
+	_, err = fmt.Fprintf(w, "%s: %s\n", fqn.Qn(), ci.Hash)
+	return err
+}
+
+// ImportCache verifies the bundle at bundlePath and installs its entries into the caches
+// of their projects
+func (eng *Engine) ImportCache(w io.Writer, bundlePath string) error {
+	b, err := cache.OpenBundle(bundlePath)
+	if err != nil {
+		return fmt.Errorf("opening bundle: %w", err)
+	}
+	defer b.Close()
+
+	for _, e := range b.Entries() {
+		proj, ok := eng.Projects[e.Project]
+		if !ok {
+			return fmt.Errorf("bundle entry %s belongs to the unknown project %s", e.Key, e.Project)
+		}
+
+		if err := proj.Cache.ImportEntry(b, e); err != nil {
+			return fmt.Errorf("importing %s: %w", e.Key, err)
+		}
+
+		if _, err := fmt.Fprintf(w, "//%s/%s\n", e.Project, e.Key); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "imported %d entries from %s\n", len(b.Entries()), bundlePath)
+	return err
+}
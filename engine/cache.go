@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"github.com/zen-io/zen-engine/cache"
+)
+
+// loadCacheWithDeps loads the cache of a target after the caches of all its build deps,
+// since the hash of a target depends on the hashes of its deps
+func (eng *Engine) loadCacheWithDeps(targetFqn string) (*cache.CacheItem, error) {
+	fqn, err := zen_targets.NewFqnFromStr(targetFqn)
+	if err != nil {
+		return nil, err
+	}
+
+	ts, err := eng.ResolveTarget(fqn)
+	if err != nil {
+		return nil, err
+	}
+	target := ts[0]
+
+	deps, err := eng.buildDeps(target)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dep := range deps {
+		if _, err := eng.loadCacheWithDeps(dep); err != nil {
+			return nil, fmt.Errorf("loading dep %s: %w", dep, err)
+		}
+	}
+
+	return eng.loadTargetCache(target)
+}
+
+// buildDeps resolves the build deps of a target to the build steps they stand for, the same
+// way the graph does, so ":all" deps expand to every target of the package with a build
+func (eng *Engine) buildDeps(target *zen_targets.Target) ([]string, error) {
+	deps := []string{}
+	for _, dep := range target.Scripts["build"].Deps {
+		depFqn, err := zen_targets.NewFqnFromStr(dep)
+		if err != nil {
+			return nil, err
+		}
+
+		depTargets, err := eng.ResolveTarget(depFqn)
+		if err != nil {
+			return nil, fmt.Errorf("getting all targets for %s: %w", dep, err)
+		}
+
+		for _, t := range depTargets {
+			if t.Scripts["build"] != nil {
+				deps = append(deps, fmt.Sprintf("%s:build", t.Qn()))
+			}
+		}
+	}
+
+	return deps, nil
+}
+
+// runtimeAttributes are the attributes of the block a target was declared in that tell how to run
+// its scripts, see parser.RuntimeAttributes
+func (eng *Engine) runtimeAttributes(target *zen_targets.Target) map[string]interface{} {
+	if block, ok := eng.PackageParser.TargetDefinition(target.Qn()); ok && block.Runtime != nil {
+		return block.Runtime
+	}
+
+	return map[string]interface{}{}
+}
+
+// loadTargetCache loads the cache of a target whose build deps are already loaded
+func (eng *Engine) loadTargetCache(target *zen_targets.Target) (*cache.CacheItem, error) {
+	def := &cache.TargetDefinition{
+		DepHashes: map[string]string{},
+	}
+
+	if block, ok := eng.PackageParser.TargetDefinition(target.Qn()); ok {
+		def.Type = block.Type
+		def.Digest = block.Digest
+	}
+
+	deps, err := eng.buildDeps(target)
+	if err != nil {
+		return nil, err
+	}
+
+	// deps can live in other projects, each with its own cache
+	for _, dep := range deps {
+		depFqn, err := zen_targets.NewFqnFromStr(dep)
+		if err != nil {
+			return nil, err
+		}
+
+		if def.DepHashes[dep], err = eng.Projects[depFqn.Project()].Cache.TargetHash(dep); err != nil {
+			return nil, fmt.Errorf("getting dep hash: %w", err)
+		}
+	}
+
+	return eng.Projects[target.Project()].Cache.LoadTargetCache(target, def)
+}
+
+// ExplainTargets writes, for every target matching args, which inputs changed since its last build
+func (eng *Engine) ExplainTargets(w io.Writer, args []string) error {
+	ts, err := eng.ExpandTargets(args, "build")
+	if err != nil {
+		return fmt.Errorf("expanding targets: %w", err)
+	}
+
+	for _, t := range ts {
+		fqn, err := zen_targets.NewFqnFromStr(t)
+		if err != nil {
+			return err
+		}
+
+		if _, err := eng.loadCacheWithDeps(fqn.BuildFqn()); err != nil {
+			return fmt.Errorf("loading cache for %s: %w", t, err)
+		}
+
+		exp, err := eng.Projects[fqn.Project()].Cache.ExplainTarget(fqn.BuildFqn())
+		if err != nil {
+			return fmt.Errorf("explaining %s: %w", t, err)
+		}
+
+		if _, err := io.WriteString(w, exp.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GarbageCollectCache evicts the cache entries of every project according to its gc settings,
+// and writes what was (or would be, with dryRun) removed
+func (eng *Engine) GarbageCollectCache(w io.Writer, dryRun bool) error {
+	for projName, proj := range eng.Projects {
+		report, err := proj.Cache.GarbageCollect(dryRun)
+		if err != nil {
+			return fmt.Errorf("collecting %s cache: %w", projName, err)
+		}
+
+		for _, e := range report.Evicted {
+			if _, err := fmt.Fprintf(w, "//%s/%s: %d bytes\n", projName, e.Key, e.Size); err != nil {
+				return err
+			}
+		}
+
+		if _, err := fmt.Fprintf(w, "%s: evicted %d entries (%d bytes), kept %d\n", projName, len(report.Evicted), report.FreedBytes, report.Kept); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveFileHashes persists the file hash index of every project, after a run
+func (eng *Engine) saveFileHashes() {
+	for projName, proj := range eng.Projects {
+		if err := proj.Cache.SaveFileHashes(); err != nil {
+			eng.Errorln("saving %s file hashes: %s", projName, err)
+		}
+	}
+}
+
+// autoGarbageCollect runs the gc for the projects that enable auto_gc, after a run
+func (eng *Engine) autoGarbageCollect() {
+	for projName, proj := range eng.Projects {
+		if autoGC := proj.Config.Cache.AutoGC; autoGC == nil || !*autoGC {
+			continue
+		}
+
+		if report, err := proj.Cache.GarbageCollect(false); err != nil {
+			eng.Errorln("collecting %s cache: %s", projName, err)
+		} else {
+			eng.Debugln("collected %s cache: evicted %d entries (%d bytes)", projName, len(report.Evicted), report.FreedBytes)
+		}
+	}
+}
+
+// VerifyCache checks the stored outputs of every project against their metadata, and writes the
+// corrupt entries found. With quarantine, corrupt entries are moved out of the cache.
+func (eng *Engine) VerifyCache(w io.Writer, quarantine bool) error {
+	for projName, proj := range eng.Projects {
+		report, err := proj.Cache.Verify(quarantine)
+		if err != nil {
+			return fmt.Errorf("verifying %s cache: %w", projName, err)
+		}
+
+		for _, c := range report.Corrupt {
+			for _, p := range c.Problems {
+				if _, err := fmt.Fprintf(w, "//%s/%s (%s): %s\n", projName, c.Key, c.Source, p); err != nil {
+					return err
+				}
+			}
+		}
+
+		if _, err := fmt.Fprintf(w, "%s: checked %d entries, %d corrupt, %d quarantined, %d could not be verified\n",
+			projName, report.Checked, len(report.Corrupt), len(report.Quarantined), len(report.Unverified)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
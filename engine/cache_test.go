@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"github.com/zen-io/zen-core/utils"
+	"github.com/zen-io/zen-engine/cache"
+	"github.com/zen-io/zen-engine/config"
+	"github.com/zen-io/zen-engine/parser"
+
+	"gotest.tools/v3/assert"
+)
+
+// mockBlockConfig is a block type declaring a single target that does nothing
+type mockBlockConfig struct {
+	Name   string   `mapstructure:"name"`
+	Labels []string `mapstructure:"labels"`
+}
+
+func (mc mockBlockConfig) GetTargets(_ *zen_targets.TargetConfigContext) ([]*zen_targets.Target, error) {
+	return []*zen_targets.Target{
+		zen_targets.NewTarget(
+			mc.Name,
+			zen_targets.WithLabels(mc.Labels),
+			zen_targets.WithTargetScript("build", &zen_targets.TargetScript{
+				Run: func(_ *zen_targets.Target, _ *zen_targets.RuntimeContext) error { return nil },
+			}),
+		),
+	}, nil
+}
+
+// mockParsedEngine is an engine with a single project in root, whose package "pkg" is the BUILD file passed
+func mockParsedEngine(t *testing.T, root, build string) *Engine {
+	assert.NilError(t, os.MkdirAll(filepath.Join(root, "pkg"), os.ModePerm))
+	assert.NilError(t, os.WriteFile(filepath.Join(root, "pkg", "BUILD"), []byte(build), 0644))
+
+	projConfig := &config.ProjectConfig{
+		Path:   root,
+		Parse:  &config.ProjectParseConfig{Filename: "BUILD"},
+		Build:  &config.ProjectBuildConfig{Variables: map[string]string{}},
+		Deploy: &config.ProjectDeployConfig{},
+	}
+
+	cm, err := cache.NewCacheManager(&cache.CacheConfig{
+		Tmp:       utils.StringPtr(filepath.Join(root, ".zen", "tmp")),
+		Metadata:  utils.StringPtr(filepath.Join(root, ".zen", "metadata")),
+		Out:       utils.StringPtr(filepath.Join(root, ".zen", "out")),
+		Artifacts: utils.StringPtr(filepath.Join(root, ".zen", "artifacts")),
+	})
+	assert.NilError(t, err)
+
+	pp, err := parser.NewPackageParser()
+	assert.NilError(t, err)
+	pp.Initialize(map[string]*config.ProjectConfig{"project": projConfig})
+	pp.RegisterTargetTypes("project", zen_targets.TargetCreatorMap{"mock": mockBlockConfig{}})
+
+	return &Engine{
+		PackageParser: pp,
+		Projects:      map[string]*config.Project{"project": {Config: projConfig, Cache: cm}},
+		targets:       map[string]map[string]map[string]*zen_targets.Target{"project": {}},
+	}
+}
+
+func TestLoadTargetCacheIgnoresRuntimeAttributes(t *testing.T) {
+	// the hash depends on where the package lives
+	root := t.TempDir()
+	hashOf := func(block string) (string, *Engine) {
+		eng := mockParsedEngine(t, root, fmt.Sprintf("mock {\n%s\n}\n", block))
+		ci, err := eng.loadCacheWithDeps("//project/pkg:app:build")
+		assert.NilError(t, err)
+		return ci.Hash, eng
+	}
+
+	plain, _ := hashOf(`name = "app"`)
+	tuned, eng := hashOf(`
+name = "app"
+timeout = "10m"
+retries = { build = 2 }
+cpu = 2
+`)
+	assert.Equal(t, tuned, plain)
+
+	// the engine still gets them from the definition
+	ts, err := eng.ResolveTarget(mustFqn(t, "//project/pkg:app"))
+	assert.NilError(t, err)
+	policy, err := parseScriptPolicy(eng.runtimeAttributes(ts[0]), "build")
+	assert.NilError(t, err)
+	assert.Equal(t, policy.timeout, 10*time.Minute)
+	assert.Equal(t, policy.retries, 2)
+	res, err := parseResources(eng.runtimeAttributes(ts[0]))
+	assert.NilError(t, err)
+	assert.Equal(t, res.Cpu, 2)
+
+	// the rest of the block is still hashed
+	labelled, _ := hashOf(`
+name = "app"
+labels = ["release"]
+`)
+	assert.Assert(t, labelled != plain)
+}
+
+func mustFqn(t *testing.T, s string) *zen_targets.QualifiedTargetName {
+	fqn, err := zen_targets.NewFqnFromStr(s)
+	assert.NilError(t, err)
+	return fqn
+}
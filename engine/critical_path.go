@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+)
+
+// defaultStepCost is the estimate for steps without a recorded duration
+const defaultStepCost = time.Second
+
+// stepTimes records how long each step of the run took, excluding the time waiting for resources
+type stepTimes struct {
+	mu        sync.Mutex
+	durations map[string]time.Duration
+}
+
+func (st *stepTimes) record(step string, d time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.durations[step] = d
+}
+
+func (st *stepTimes) get(step string) (time.Duration, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	d, ok := st.durations[step]
+	return d, ok
+}
+
+// estimateCost returns the average of the latest build durations of a step, from the cache metadata
+func (eng *Engine) estimateCost(step string) time.Duration {
+	fqn, err := zen_targets.NewFqnFromStr(step)
+	if err != nil || fqn.Script() != "build" {
+		return defaultStepCost
+	}
+
+	proj, ok := eng.Projects[fqn.Project()]
+	if !ok {
+		return defaultStepCost
+	}
+
+	if d, ok := proj.Cache.EstimatedBuildDuration(fqn.Package(), fqn.Name()); ok {
+		return d
+	}
+
+	return defaultStepCost
+}
+
+// prioritize sets the priority of every step in the graph to the estimated cost of the longest
+// path from it to the end of the run, so the steps on the critical path are started first
+func (eng *Engine) prioritize() {
+	eng.costs = map[string]time.Duration{}
+	eng.priorities = map[string]time.Duration{}
+
+	var visit func(step string) time.Duration
+	visit = func(step string) time.Duration {
+		if p, ok := eng.priorities[step]; ok {
+			return p
+		}
+
+		var longest time.Duration
+		for _, next := range eng.edges[step] {
+			if p := visit(next); p > longest {
+				longest = p
+			}
+		}
+
+		eng.costs[step] = eng.estimateCost(step)
+		eng.priorities[step] = eng.costs[step] + longest
+		return eng.priorities[step]
+	}
+
+	for step := range eng.edges {
+		visit(step)
+	}
+}
+
+// criticalPath follows the steps with the longest remaining path, from the start of the run
+func (eng *Engine) criticalPath() []string {
+	hasDeps := map[string]bool{}
+	for _, next := range eng.edges {
+		for _, n := range next {
+			hasDeps[n] = true
+		}
+	}
+
+	steps := make([]string, 0, len(eng.edges))
+	for step := range eng.edges {
+		steps = append(steps, step)
+	}
+	sort.Strings(steps)
+
+	next := func(candidates []string) string {
+		best := ""
+		for _, c := range candidates {
+			if best == "" || eng.priorities[c] > eng.priorities[best] {
+				best = c
+			}
+		}
+		return best
+	}
+
+	roots := []string{}
+	for _, step := range steps {
+		if !hasDeps[step] {
+			roots = append(roots, step)
+		}
+	}
+
+	path := []string{}
+	for step := next(roots); step != ""; step = next(eng.edges[step]) {
+		path = append(path, step)
+	}
+
+	return path
+}
+
+// reportCriticalPath writes the critical path of the run, with the estimated and actual
+// duration of every step on it
+func (eng *Engine) reportCriticalPath() {
+	path := eng.criticalPath()
+	if len(path) == 0 {
+		return
+	}
+
+	eng.Infoln("critical path (estimated %s):", eng.priorities[path[0]].Round(time.Millisecond))
+	for _, step := range path {
+		took := "did not run"
+		if d, ok := eng.stepTimes.get(step); ok {
+			took = "took " + d.Round(time.Millisecond).String()
+		}
+
+		eng.Infoln("  %s: estimated %s, %s", step, eng.costs[step].Round(time.Millisecond), took)
+	}
+}
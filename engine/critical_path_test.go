@@ -0,0 +1,28 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestCriticalPathFollowsLongestChain(t *testing.T) {
+	eng := &Engine{
+		edges: map[string][]string{
+			"//proj/lib:a:build": {"//proj/app:b:build", "//proj/app:c:build"},
+			"//proj/app:b:build": {"//proj/app:d:build"},
+			"//proj/app:c:build": {},
+			"//proj/app:d:build": {},
+			"//proj/app:e:build": {},
+		},
+	}
+
+	eng.prioritize()
+	assert.Equal(t, eng.priorities["//proj/lib:a:build"], 3*defaultStepCost)
+	assert.Equal(t, eng.priorities["//proj/app:c:build"], defaultStepCost)
+	assert.DeepEqual(t, eng.criticalPath(), []string{"//proj/lib:a:build", "//proj/app:b:build", "//proj/app:d:build"})
+
+	assert.Assert(t, eng.priorities["//proj/app:b:build"] > eng.priorities["//proj/app:e:build"])
+	assert.Equal(t, eng.priorities["//proj/app:e:build"], time.Second)
+}
@@ -48,7 +48,14 @@ func (eng *Engine) recursiveAddTargetsToGraph(targets []string) error {
 	}
 
 	// actually add to the graph
-	eng.AddVertex(targetFqn, func() error { return eng._run_step(targetFqn) })
+	res, err := parseResources(eng.runtimeAttributes(target))
+	if err != nil {
+		return fmt.Errorf("target %s resources: %w", targetFqn, err)
+	}
+	eng.AddVertex(targetFqn, func() error { return eng.runVertex(eng.runCtx, targetFqn, res) })
+	if eng.edges[targetFqn] == nil {
+		eng.edges[targetFqn] = []string{}
+	}
 	if eng.targets[target.Project()][target.Package()][target.Name] == nil {
 		eng.targets[target.Project()][target.Package()][target.Name] = target
 	}
@@ -60,17 +67,27 @@ func (eng *Engine) recursiveAddTargetsToGraph(targets []string) error {
 
 	for _, dFqn := range depFqns {
 		targets = append(targets, dFqn)
-		eng.AddEdge(dFqn, targetFqn)
+		eng.addEdge(dFqn, targetFqn)
 	}
 
 	if fqn.Script() != "build" {
 		targets = append(targets, fqn.BuildFqn())
-		eng.AddEdge(fqn.BuildFqn(), targetFqn)
+		eng.addEdge(fqn.BuildFqn(), targetFqn)
 	}
 
 	return eng.recursiveAddTargetsToGraph(targets)
 }
 
+// addEdge adds the edge to the graph, and keeps it to estimate the critical path and to
+// skip the dependents of failed steps
+func (eng *Engine) addEdge(from, to string) {
+	eng.AddEdge(from, to)
+	if !slices.Contains(eng.edges[from], to) {
+		eng.edges[from] = append(eng.edges[from], to)
+		eng.deps[to] = append(eng.deps[to], from)
+	}
+}
+
 func (eng *Engine) getDependenciesToAdd(script string, target *zen_targets.Target, leftoverTargets []string) ([]string, error) {
 	depsToCheck := []string{}
 	for _, depFqn := range target.Scripts[script].Deps {
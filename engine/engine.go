@@ -1,15 +1,20 @@
 package engine
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"time"
 
 	"github.com/zen-io/zen-core/target"
 	zen_targets "github.com/zen-io/zen-core/target"
 	"github.com/zen-io/zen-engine/cache"
 	"github.com/zen-io/zen-engine/config"
 	"github.com/zen-io/zen-engine/parser"
+	"github.com/zen-io/zen-engine/sandbox"
 
 	"github.com/spf13/pflag"
 	dag "github.com/tiagoposse/go-dag"
@@ -27,6 +32,24 @@ type Engine struct {
 	*dag.DAG
 
 	prePostFns map[string]*RunFnMap
+	sandbox    bool
+	scheduler  *Scheduler
+
+	// run state
+	runCtx      context.Context
+	cancelRun   context.CancelFunc
+	keepGoing   bool
+	failFast    bool
+	gracePeriod time.Duration
+	groups      *processGroups
+	summary     *runSummary
+	deps        map[string][]string
+
+	// critical path
+	edges      map[string][]string
+	costs      map[string]time.Duration
+	priorities map[string]time.Duration
+	stepTimes  *stepTimes
 
 	*out_mgr.TaskLoggerImpl
 	*parser.PackageParser
@@ -43,12 +66,21 @@ func NewEngine() (*Engine, error) {
 		return nil, err
 	}
 
+	runCtx, cancelRun := context.WithCancel(context.Background())
 	eng := &Engine{
+		runCtx:        runCtx,
+		cancelRun:     cancelRun,
 		cliconfig:     cfg,
 		Projects:      make(map[string]*config.Project),
 		targets:       make(map[string]map[string]map[string]*target.Target),
 		PackageParser: parser,
 		prePostFns:    make(map[string]*RunFnMap),
+		edges:         make(map[string][]string),
+		deps:          make(map[string][]string),
+		summary:       newRunSummary(),
+		gracePeriod:   defaultGracePeriod,
+		groups:        newProcessGroups(),
+		stepTimes:     &stepTimes{durations: map[string]time.Duration{}},
 	}
 
 	return eng, nil
@@ -79,6 +111,21 @@ func (eng *Engine) Initialize(flags *pflag.FlagSet) (err error) {
 		uiOpts = append(uiOpts, out_mgr.WithRawOutput())
 	}
 
+	// a sandboxed step writes into the output of its parent
+	if os.Getenv(sandbox.EnvVar) != "" {
+		uiOpts = append(uiOpts, out_mgr.WithRawOutput())
+	}
+	eng.sandbox, _ = flags.GetBool("sandbox")
+
+	eng.keepGoing, _ = flags.GetBool("keep-going")
+	eng.failFast, _ = flags.GetBool("fail-fast")
+	if eng.keepGoing && eng.failFast {
+		return fmt.Errorf("--keep-going and --fail-fast cannot be used together")
+	}
+	if grace, err := flags.GetDuration("grace-period"); err == nil && grace > 0 {
+		eng.gracePeriod = grace
+	}
+
 	// output.WithLogsRoot(*config.Cache.Exec),
 	ui, err := out_mgr.NewOutputManager(uiOpts...)
 	if err != nil {
@@ -93,9 +140,26 @@ func (eng *Engine) Initialize(flags *pflag.FlagSet) (err error) {
 		return err
 	}
 
+	// Setup the scheduler. The DAG starts every ready step, and the scheduler decides which run.
+	jobs, _ := flags.GetInt("jobs")
+	if jobs <= 0 && eng.cliconfig.Build.Jobs != nil {
+		jobs = *eng.cliconfig.Build.Jobs
+	}
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	var memory int64
+	if eng.cliconfig.Build.Memory != nil {
+		if memory, err = cache.ParseSize(eng.cliconfig.Build.Memory); err != nil {
+			return fmt.Errorf("parsing build memory: %w", err)
+		}
+	}
+	eng.scheduler = NewScheduler(jobs, memory, eng.cliconfig.Build.Exclusive)
+
 	// Setup the DAG
 	dagOpts := []dag.Option{
-		dag.WithMaxParallel(30),
+		dag.WithMaxParallel(0),
 	}
 
 	if int(ui.Verbosity()) >= int(out_mgr.Debug) {
@@ -104,6 +168,8 @@ func (eng *Engine) Initialize(flags *pflag.FlagSet) (err error) {
 
 	eng.DAG = dag.NewDAG(dagOpts...)
 
+	rehash, _ := flags.GetBool("rehash")
+
 	projConfigs := make(map[string]*config.ProjectConfig)
 	for projName, projPath := range eng.cliconfig.Global.Projects {
 		projConfig, err := config.LoadProjectConfig(filepath.Join(projPath, ".zenconfig"), eng.cliconfig)
@@ -111,9 +177,19 @@ func (eng *Engine) Initialize(flags *pflag.FlagSet) (err error) {
 			return fmt.Errorf("loading project %s: %w", projName, err)
 		}
 
+		cacheManager, err := cache.NewCacheManager(
+			projConfig.Cache,
+			cache.WithToolchains(projConfig.Build.Toolchains),
+			cache.WithForceRehash(rehash),
+			cache.WithProjectRoot(projPath),
+		)
+		if err != nil {
+			return fmt.Errorf("loading project %s cache: %w", projName, err)
+		}
+
 		eng.Projects[projName] = &config.Project{
 			Config: projConfig,
-			Cache:  cache.NewCacheManager(projConfig.Cache),
+			Cache:  cacheManager,
 		}
 
 		eng.targets[projName] = make(map[string]map[string]*zen_targets.Target)
@@ -136,24 +212,76 @@ func (e *Engine) Done() {
 	e.Debug("finished")
 }
 
-func (eng *Engine) CleanCache(args []string) error {
+// CleanCache removes the cache of the targets matching args, or the whole cache of every
+// project when no args are passed. With dryRun, it only writes what would be removed.
+func (eng *Engine) CleanCache(w io.Writer, args []string, dryRun bool) error {
 	if len(args) == 0 {
 		for _, proj := range eng.Projects {
-			if err := os.RemoveAll(*proj.Config.Cache.Tmp); err != nil {
-				return err
+			paths := []*string{proj.Config.Cache.Tmp, proj.Config.Cache.Metadata, proj.Config.Cache.Out, proj.Config.Cache.Artifacts}
+			for _, p := range paths {
+				if p == nil {
+					continue
+				}
+
+				if dryRun {
+					if _, err := fmt.Fprintf(w, "would remove %s\n", *p); err != nil {
+						return err
+					}
+				} else if err := os.RemoveAll(*p); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	ts, err := eng.ExpandTargets(args, "build")
+	if err != nil {
+		return fmt.Errorf("expanding targets: %w", err)
+	}
+
+	cleaned := map[string]bool{}
+	for _, t := range ts {
+		fqn, err := target.NewFqnFromStr(t)
+		if err != nil {
+			return err
+		}
+
+		if cleaned[fqn.Qn()] {
+			continue
+		}
+		cleaned[fqn.Qn()] = true
+
+		// the cache folders are found by name, so the target is not hashed
+		cm := eng.Projects[fqn.Project()].Cache
+		if !dryRun {
+			if err := cm.DeleteTargetCache(fqn.Package(), fqn.Name()); err != nil {
+				return fmt.Errorf("cleaning %s: %w", fqn.Qn(), err)
 			}
-			if err := os.RemoveAll(*proj.Config.Cache.Metadata); err != nil {
+			continue
+		}
+
+		keys, err := cm.TargetArtifactKeys(fqn.Package(), fqn.Name())
+		if err != nil {
+			return fmt.Errorf("listing %s artifacts: %w", fqn.Qn(), err)
+		}
+		for _, k := range keys {
+			if _, err := fmt.Fprintf(w, "%s: would remove artifact %s\n", fqn.Qn(), k); err != nil {
 				return err
 			}
-			if err := os.RemoveAll(*proj.Config.Cache.Out); err != nil {
+		}
+
+		paths, err := cm.TargetCachePaths(fqn.Package(), fqn.Name())
+		if err != nil {
+			return fmt.Errorf("listing %s cache: %w", fqn.Qn(), err)
+		}
+		for _, p := range paths {
+			if _, err := fmt.Fprintf(w, "%s: would remove %s\n", fqn.Qn(), p); err != nil {
 				return err
 			}
 		}
-		return nil
 	}
-	//  else {
-	// 	return eng.NoBuildGraphAndRun(args, "clean")
-	// }
+
 	return nil
 }
 
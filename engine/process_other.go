@@ -0,0 +1,23 @@
+//go:build !unix
+
+package engine
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+// setProcessGroup is a no op, only the step process itself can be stopped
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// stopProcessGroup kills the step process, there are no signals to ask it to stop
+func stopProcessGroup(pid int, grace time.Duration) {
+	killProcessGroup(pid)
+}
+
+func killProcessGroup(pid int) {
+	if p, err := os.FindProcess(pid); err == nil {
+		p.Kill()
+	}
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package engine
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setProcessGroup starts the command in a process group of its own, so the command and
+// everything it starts can be signalled at once
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// stopProcessGroup sends SIGTERM to the group led by pid, and SIGKILL when processes are left
+// in it after the grace period
+func stopProcessGroup(pid int, grace time.Duration) {
+	if err := syscall.Kill(-pid, syscall.SIGTERM); err != nil {
+		return
+	}
+
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) {
+		// fails once no process is left in the group
+		if err := syscall.Kill(-pid, 0); err != nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	killProcessGroup(pid)
+}
+
+func killProcessGroup(pid int) {
+	syscall.Kill(-pid, syscall.SIGKILL)
+}
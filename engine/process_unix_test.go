@@ -0,0 +1,95 @@
+//go:build unix
+
+package engine
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestStopProcessGroup(t *testing.T) {
+	polite := exec.Command("sh", "-c", "sleep 30 & wait")
+	setProcessGroup(polite)
+	assert.NilError(t, polite.Start())
+
+	// the group is empty once its processes are reaped
+	exited := make(chan error, 1)
+	go func() { exited <- polite.Wait() }()
+
+	start := time.Now()
+	stopProcessGroup(polite.Process.Pid, time.Minute)
+	assert.ErrorContains(t, <-exited, "signal")
+	assert.Assert(t, time.Since(start) < 10*time.Second)
+
+	// the scripts ignoring SIGTERM are killed after the grace period
+	stubborn := exec.Command("sh", "-c", "trap '' TERM; echo trapped; sleep 30 & wait; sleep 30")
+	setProcessGroup(stubborn)
+	stdout, err := stubborn.StdoutPipe()
+	assert.NilError(t, err)
+	assert.NilError(t, stubborn.Start())
+
+	// wait for the trap to be set
+	_, err = bufio.NewReader(stdout).ReadString('\n')
+	assert.NilError(t, err)
+
+	go func() { exited <- stubborn.Wait() }()
+
+	start = time.Now()
+	stopProcessGroup(stubborn.Process.Pid, 200*time.Millisecond)
+	assert.Assert(t, time.Since(start) >= 200*time.Millisecond)
+	assert.ErrorContains(t, <-exited, "signal")
+
+	// nothing is left in the group
+	assert.Assert(t, processGone(-stubborn.Process.Pid))
+}
+
+func TestRunScriptStopsItsProcessGroup(t *testing.T) {
+	target := mockStubbornTarget()
+	eng, ci := mockStep(t, target, false)
+	pidFile := filepath.Join(ci.BuildCachePath(), "script.pid")
+
+	// cancel the run once the script is running
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			if _, err := os.Stat(pidFile); err == nil {
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	assert.ErrorContains(t, eng.runScript(ctx, "//project/pkg:stubborn:build", "build", target, ci, true), "signal")
+	assert.Assert(t, time.Since(start) < 10*time.Second)
+
+	// the script ignored SIGTERM, so it was killed after the grace period
+	data, err := os.ReadFile(pidFile)
+	assert.NilError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	assert.NilError(t, err)
+	assert.Assert(t, processGone(pid))
+}
+
+// processGone waits for a killed process to be reaped
+func processGone(pid int) bool {
+	for i := 0; i < 100; i++ {
+		if syscall.Kill(pid, 0) != nil {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return false
+}
@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	defaultRetryBackoff = time.Second
+	maxRetryBackoff     = time.Minute
+)
+
+var errStepTimeout = errors.New("timed out")
+
+// scriptPolicy is how long a script may run, and how many times it is retried when it fails.
+// Targets declare it with attributes of their block, for every script or per script:
+// timeout = "10m", timeout = { deploy = "5m" }, retries = 2, retries = { build = 3 } and
+// retry_backoff = "5s".
+type scriptPolicy struct {
+	timeout time.Duration // 0 means no timeout
+	retries int
+	backoff time.Duration
+}
+
+func parseScriptPolicy(attrs map[string]interface{}, script string) (*scriptPolicy, error) {
+	policy := &scriptPolicy{backoff: defaultRetryBackoff}
+
+	for _, key := range []string{"timeout", "retry_backoff"} {
+		value, ok := scriptAttribute(attrs, key, script)
+		if !ok {
+			continue
+		}
+
+		str, _ := value.(string)
+		d, err := time.ParseDuration(str)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s has to be a positive duration, not %v", key, value)
+		}
+
+		if key == "timeout" {
+			policy.timeout = d
+		} else {
+			policy.backoff = d
+		}
+	}
+
+	if value, ok := scriptAttribute(attrs, "retries", script); ok {
+		retries, ok := value.(float64)
+		if !ok || retries < 0 || retries != math.Trunc(retries) {
+			return nil, fmt.Errorf("retries has to be a positive number, not %v", value)
+		}
+		policy.retries = int(retries)
+	}
+
+	return policy, nil
+}
+
+// scriptAttribute returns the value of a runtime attribute for the script. An attribute is set for
+// every script, or as a map with a value per script.
+func scriptAttribute(attrs map[string]interface{}, key, script string) (interface{}, bool) {
+	value, ok := attrs[key]
+	if !ok {
+		return nil, false
+	}
+
+	if perScript, ok := value.(map[string]interface{}); ok {
+		value, ok = perScript[script]
+		return value, ok
+	}
+
+	return value, true
+}
+
+// retry runs fn until it succeeds or the retries are used up, waiting between attempts with an
+// exponential backoff. Timeouts are retried like any other failure. It returns the attempts made.
+func retry(ctx context.Context, policy *scriptPolicy, fn func() error, onRetry func(attempt int, err error, wait time.Duration)) (int, error) {
+	wait := policy.backoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > policy.retries || ctx.Err() != nil {
+			return attempt, err
+		}
+
+		onRetry(attempt, err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return attempt, err
+		}
+
+		if wait *= 2; wait > maxRetryBackoff {
+			wait = maxRetryBackoff
+		}
+	}
+}
+
+// runWithTimeout runs fn with a context that is done after timeout, if there is one. The step
+// stops its scripts when the context is done, so timeouts are told apart from cancelled runs.
+func runWithTimeout(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := fn(attemptCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s", errStepTimeout, timeout)
+	}
+
+	return err
+}
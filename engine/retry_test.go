@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParseScriptPolicy(t *testing.T) {
+	// as decoded from the block
+	attrs := map[string]interface{}{
+		"timeout":       "10m",
+		"retries":       map[string]interface{}{"build": float64(2)},
+		"retry_backoff": "5s",
+		"cpu":           float64(2),
+	}
+
+	build, err := parseScriptPolicy(attrs, "build")
+	assert.NilError(t, err)
+	assert.Equal(t, build.timeout, 10*time.Minute)
+	assert.Equal(t, build.retries, 2)
+	assert.Equal(t, build.backoff, 5*time.Second)
+
+	deploy, err := parseScriptPolicy(map[string]interface{}{"timeout": map[string]interface{}{"deploy": "1m"}}, "deploy")
+	assert.NilError(t, err)
+	assert.Equal(t, deploy.timeout, time.Minute)
+	assert.Equal(t, deploy.retries, 0)
+	assert.Equal(t, deploy.backoff, defaultRetryBackoff)
+
+	_, err = parseScriptPolicy(map[string]interface{}{"retries": float64(-1)}, "build")
+	assert.ErrorContains(t, err, "retries has to be a positive number")
+	_, err = parseScriptPolicy(map[string]interface{}{"retries": 1.5}, "build")
+	assert.ErrorContains(t, err, "retries has to be a positive number")
+	_, err = parseScriptPolicy(map[string]interface{}{"timeout": map[string]interface{}{"build": "soon"}}, "build")
+	assert.ErrorContains(t, err, "timeout has to be a positive duration")
+}
+
+func TestRetryBacksOff(t *testing.T) {
+	policy := &scriptPolicy{retries: 3, backoff: time.Millisecond}
+
+	calls := 0
+	waits := []time.Duration{}
+	attempts, err := retry(context.Background(), policy, func() error {
+		if calls++; calls < 3 {
+			return errors.New("flaky")
+		}
+		return nil
+	}, func(attempt int, err error, wait time.Duration) {
+		waits = append(waits, wait)
+	})
+
+	assert.NilError(t, err)
+	assert.Equal(t, attempts, 3)
+	assert.DeepEqual(t, waits, []time.Duration{time.Millisecond, 2 * time.Millisecond})
+
+	// retries are used up
+	attempts, err = retry(context.Background(), policy, func() error { return errors.New("broken") }, func(int, error, time.Duration) {})
+	assert.ErrorContains(t, err, "broken")
+	assert.Equal(t, attempts, 4)
+
+	// a cancelled run is not retried
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts, _ = retry(ctx, policy, func() error { return errors.New("interrupted") }, func(int, error, time.Duration) {})
+	assert.Equal(t, attempts, 1)
+}
+
+func TestRunWithTimeout(t *testing.T) {
+	waitForCtx := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	err := runWithTimeout(context.Background(), 50*time.Millisecond, waitForCtx)
+	assert.Assert(t, errors.Is(err, errStepTimeout))
+
+	// a cancelled run is not a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = runWithTimeout(ctx, time.Minute, waitForCtx)
+	assert.Assert(t, errors.Is(err, context.Canceled))
+	assert.Assert(t, !errors.Is(err, errStepTimeout))
+
+	assert.NilError(t, runWithTimeout(context.Background(), time.Minute, func(context.Context) error { return nil }))
+}
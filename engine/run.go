@@ -1,13 +1,17 @@
 package engine
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/zen-io/zen-core/target"
 	"github.com/zen-io/zen-core/utils"
 	"github.com/zen-io/zen-engine/cache"
+	"github.com/zen-io/zen-engine/sandbox"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -16,16 +20,18 @@ import (
 // special error that signals to stop the execution without errors
 type DoNotContinue struct{}
 
+// RunFnMap holds the functions run before and after a script. ctx is done when the run is
+// interrupted, or when another step fails with --fail-fast, and they should stop their work then.
 type RunFnMap struct {
-	Pre  func(eng *Engine, target *target.Target, ci *cache.CacheItem) error
-	Post func(eng *Engine, target *target.Target, ci *cache.CacheItem) error
+	Pre  func(ctx context.Context, eng *Engine, target *target.Target, ci *cache.CacheItem) error
+	Post func(ctx context.Context, eng *Engine, target *target.Target, ci *cache.CacheItem) error
 }
 
 func (dnc DoNotContinue) Error() string {
 	return "do not continue"
 }
 
-func (eng *Engine) _run_step(targetFqn string) error {
+func (eng *Engine) _run_step(ctx context.Context, targetFqn string) error {
 	fqn, err := target.NewFqnFromStr(targetFqn)
 	if err != nil {
 		return err
@@ -41,7 +47,7 @@ func (eng *Engine) _run_step(targetFqn string) error {
 
 	// load cache
 	var ci *cache.CacheItem
-	ci, err = eng.Projects[target.Project()].Cache.LoadTargetCache(target)
+	ci, err = eng.loadTargetCache(target)
 	if err != nil {
 		return fmt.Errorf("loading cache: %w", err)
 	}
@@ -76,8 +82,12 @@ func (eng *Engine) _run_step(targetFqn string) error {
 	}
 
 	// pre run
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if eng.prePostFns[script] != nil && eng.prePostFns[script].Pre != nil {
-		if err := eng.prePostFns[script].Pre(eng, target, ci); errors.Is(err, DoNotContinue{}) {
+		if err := eng.prePostFns[script].Pre(ctx, eng, target, ci); errors.Is(err, DoNotContinue{}) {
 			return nil
 		} else if err != nil {
 			return fmt.Errorf("custom %s pre run: %w", script, err)
@@ -93,21 +103,69 @@ func (eng *Engine) _run_step(targetFqn string) error {
 	}
 
 	// run
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	policy, err := parseScriptPolicy(eng.runtimeAttributes(target), script)
+	if err != nil {
+		return fmt.Errorf("script %s policy: %w", script, err)
+	}
+
 	interpolEnv, err := utils.InterpolateMapWithItself(utils.MergeMaps(target.Env, target.Scripts[script].Env, map[string]string{"CWD": target.Cwd}))
 	if err != nil {
 		return fmt.Errorf("interpolating script %s vars: %w", script, err)
 	}
 	target.Env = interpolEnv
 
-	if err := target.Scripts[script].Run(target, eng.Ctx); err != nil {
+	if script == "build" {
+		ci.StartBuildTimer()
+	}
+
+	timeouts, tries := 0, 0
+	attempts, err := retry(ctx, policy, func() error {
+		// a retried build starts over from its srcs, without what the failed attempt left
+		if tries++; tries > 1 {
+			if err := eng.resetBuildFolder(script, target, ci); err != nil {
+				return err
+			}
+		}
+
+		err := runWithTimeout(ctx, policy.timeout, func(ctx context.Context) error {
+			return eng.runScript(ctx, targetFqn, script, target, ci, policy.timeout > 0)
+		})
+		if errors.Is(err, errStepTimeout) {
+			timeouts++
+			target.Errorln("%s %s", script, err)
+		}
+		return err
+	}, func(attempt int, err error, wait time.Duration) {
+		target.Warnln("attempt %d of %d failed: %s. Retrying in %s", attempt, policy.retries+1, err, wait)
+	})
+	eng.summary.recordAttempts(targetFqn, attempts, timeouts)
+
+	// the scripts of an interrupted step were terminated, so nothing they left is kept
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		eng.discardInterruptedStep(script, target, ci)
+		return fmt.Errorf("interrupted: %w", ctxErr)
+	}
+
+	if err != nil {
+		if timeouts > 0 {
+			eng.discardInterruptedStep(script, target, ci)
+		}
 		target.Errorln("executing run: %s", err)
 		return err
 	}
 
 	// POST RUN
+	if script == "build" {
+		reportUndeclaredOutputs(target, ci)
+	}
+
 	// custom script post run
 	if eng.prePostFns[script] != nil && eng.prePostFns[script].Post != nil {
-		if err := eng.prePostFns[script].Post(eng, target, ci); err != nil {
+		if err := eng.prePostFns[script].Post(ctx, eng, target, ci); err != nil {
 			return fmt.Errorf("custom %s post run: %w", script, err)
 		}
 	}
@@ -125,7 +183,32 @@ func (eng *Engine) _run_step(targetFqn string) error {
 	return nil
 }
 
+// reportUndeclaredOutputs warns about the files a build left in its build folder that are not
+// declared outs, since they are not cached and will be missing on a cache hit
+func reportUndeclaredOutputs(t *target.Target, ci *cache.CacheItem) {
+	if t.External || ci.BaseBuildCache == "" {
+		return
+	}
+
+	undeclared, err := ci.UndeclaredOutputs(t.Outs)
+	if err != nil {
+		t.Warnln("listing undeclared outputs: %s", err)
+	} else if len(undeclared) > 0 {
+		t.Warnln("the build produced files that are not declared outs, and are not cached: %s", strings.Join(undeclared, ", "))
+	}
+}
+
 func (eng *Engine) ParseArgsAndRun(flags *pflag.FlagSet, args []string, script string) {
+	// this process was started by runScriptInChild, to run a single step
+	if specPath := os.Getenv(sandbox.EnvVar); specPath != "" {
+		if err := eng.runStepProcess(specPath); err != nil {
+			eng.Errorln("%s", err)
+			eng.Done()
+			os.Exit(1)
+		}
+		return
+	}
+
 	shell, _ := flags.GetBool("shell")
 	if shell && len(args) > 1 {
 		eng.Errorln("when using --shell, you can pass only one target")
@@ -164,15 +247,18 @@ func (eng *Engine) ParseArgsAndRun(flags *pflag.FlagSet, args []string, script s
 		EnterTargetShell(eng.targets[fqn.Project()][fqn.Package()][fqn.Name()], fqn.Script())
 	}
 
-	if err := eng.Run(); err != nil {
-		if len(eng.Errors()) == 0 {
-			eng.Errorln("executing the graph: %w", err)
-		} else {
-			for _, v := range eng.Errors() {
-				eng.Errorln(v)
-			}
-		}
+	stopSignals := eng.handleSignals()
+	defer stopSignals()
+
+	eng.prioritize()
+	if err := eng.Run(); err != nil && len(eng.Errors()) == 0 {
+		eng.Errorln("executing the graph: %w", err)
 	}
+
+	eng.reportSummary()
+	eng.reportCriticalPath()
+	eng.saveFileHashes()
+	eng.autoGarbageCollect()
 }
 
 func (eng *Engine) AutocompleteTargets(_ *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"os/exec"
+	"path/filepath"
+
+	"github.com/zen-io/zen-core/target"
+)
+
+// sandboxEnabled tells whether the build of the target runs sandboxed, either from the
+// --sandbox flag or the project build config. External targets are never sandboxed.
+func (eng *Engine) sandboxEnabled(t *target.Target) bool {
+	if t.External {
+		return false
+	}
+
+	if eng.sandbox {
+		return true
+	}
+
+	enabled := eng.Projects[t.Project()].Config.Build.Sandbox
+	return enabled != nil && *enabled
+}
+
+// sandboxPaths are the tools and toolchains the target declares, visible read only in the sandbox
+func (eng *Engine) sandboxPaths(t *target.Target) []string {
+	declared := []string{}
+	for _, tool := range t.Tools {
+		declared = append(declared, tool)
+	}
+	for _, toolchain := range eng.Projects[t.Project()].Config.Build.Toolchains {
+		declared = append(declared, toolchain)
+	}
+
+	paths := []string{}
+	for _, p := range declared {
+		if !filepath.IsAbs(p) {
+			resolved, err := exec.LookPath(p)
+			if err != nil {
+				continue
+			}
+			p = resolved
+		}
+
+		paths = append(paths, p)
+	}
+
+	return paths
+}
@@ -0,0 +1,220 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zen-io/zen-engine/cache"
+)
+
+// Resources are what a step needs from the host while it runs. Targets declare them with
+// attributes of their block: cpu = 4, memory = "2G" and exclusive = ["docker"].
+type Resources struct {
+	Cpu       int
+	Memory    int64
+	Exclusive []string
+}
+
+func parseResources(attrs map[string]interface{}) (*Resources, error) {
+	res := &Resources{Cpu: 1, Exclusive: []string{}}
+
+	if value, ok := attrs["cpu"]; ok {
+		cpu, ok := value.(float64)
+		if !ok || cpu < 1 || cpu != math.Trunc(cpu) {
+			return nil, fmt.Errorf("cpu has to be a positive number, not %v", value)
+		}
+		res.Cpu = int(cpu)
+	}
+
+	if value, ok := attrs["memory"]; ok {
+		memory, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("memory has to be a size, like \"2G\", not %v", value)
+		}
+
+		var err error
+		if res.Memory, err = cache.ParseSize(&memory); err != nil {
+			return nil, fmt.Errorf("memory: %w", err)
+		}
+	}
+
+	switch value := attrs["exclusive"].(type) {
+	case nil:
+	case string:
+		res.Exclusive = append(res.Exclusive, value)
+	case []interface{}:
+		for _, tag := range value {
+			str, ok := tag.(string)
+			if !ok {
+				return nil, fmt.Errorf("exclusive has to hold tags, not %v", tag)
+			}
+			res.Exclusive = append(res.Exclusive, str)
+		}
+	default:
+		return nil, fmt.Errorf("exclusive has to be a tag or a list of tags, not %v", value)
+	}
+
+	return res, nil
+}
+
+type schedRequest struct {
+	res      *Resources
+	priority time.Duration
+	ready    chan struct{}
+}
+
+// Scheduler hands out cpu slots, memory and exclusive tags to steps, so no more work runs at
+// once than the host can take. Requests are granted by priority, and in arrival order for the
+// same priority. One blocked by cpu or memory holds back the ones after it, so big steps are
+// not starved, while one blocked by a tag lets the others through.
+type Scheduler struct {
+	mu      sync.Mutex
+	cpus    int
+	memory  int64 // 0 means unlimited
+	limits  map[string]int
+	waiting []*schedRequest
+
+	usedCpus   int
+	usedMemory int64
+	usedTags   map[string]int
+}
+
+// NewScheduler creates a scheduler with cpus slots and memory bytes. Every exclusive tag allows
+// one step at a time, unless limits says otherwise.
+func NewScheduler(cpus int, memory int64, limits map[string]int) *Scheduler {
+	if cpus < 1 {
+		cpus = 1
+	}
+
+	if limits == nil {
+		limits = map[string]int{}
+	}
+
+	return &Scheduler{
+		cpus:     cpus,
+		memory:   memory,
+		limits:   limits,
+		waiting:  []*schedRequest{},
+		usedTags: map[string]int{},
+	}
+}
+
+// Acquire blocks until the resources are available, and returns the function that releases them.
+// Steps with a higher priority go first. When ctx is done first, the request is withdrawn.
+func (s *Scheduler) Acquire(ctx context.Context, res *Resources, priority time.Duration) (func(), error) {
+	res = s.clamp(res)
+	req := &schedRequest{res: res, priority: priority, ready: make(chan struct{})}
+
+	s.mu.Lock()
+	i := sort.Search(len(s.waiting), func(i int) bool { return s.waiting[i].priority < priority })
+	s.waiting = append(s.waiting[:i], append([]*schedRequest{req}, s.waiting[i:]...)...)
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case <-req.ready:
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-req.ready:
+			// granted in the meantime, so it is handed back
+			s.mu.Unlock()
+			s.release(res)
+		default:
+			for i, r := range s.waiting {
+				if r == req {
+					s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+					break
+				}
+			}
+			s.mu.Unlock()
+		}
+
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { s.release(res) })
+	}, nil
+}
+
+func (s *Scheduler) release(res *Resources) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.usedCpus -= res.Cpu
+	s.usedMemory -= res.Memory
+	for _, tag := range res.Exclusive {
+		s.usedTags[tag]--
+	}
+	s.dispatch()
+}
+
+// clamp lets steps that ask for more than the host has run alone, instead of never
+func (s *Scheduler) clamp(res *Resources) *Resources {
+	clamped := *res
+	if clamped.Cpu < 1 {
+		clamped.Cpu = 1
+	} else if clamped.Cpu > s.cpus {
+		clamped.Cpu = s.cpus
+	}
+
+	if s.memory > 0 && clamped.Memory > s.memory {
+		clamped.Memory = s.memory
+	}
+
+	return &clamped
+}
+
+func (s *Scheduler) tagLimit(tag string) int {
+	if limit, ok := s.limits[tag]; ok {
+		return limit
+	}
+
+	return 1
+}
+
+// dispatch grants the waiting requests that fit. Must be called with the lock held.
+func (s *Scheduler) dispatch() {
+	waiting := []*schedRequest{}
+	blocked := false
+
+	for _, req := range s.waiting {
+		if blocked {
+			waiting = append(waiting, req)
+			continue
+		}
+
+		if s.usedCpus+req.res.Cpu > s.cpus || (s.memory > 0 && s.usedMemory+req.res.Memory > s.memory) {
+			blocked = true
+			waiting = append(waiting, req)
+			continue
+		}
+
+		tagsFree := true
+		for _, tag := range req.res.Exclusive {
+			if s.usedTags[tag] >= s.tagLimit(tag) {
+				tagsFree = false
+				break
+			}
+		}
+		if !tagsFree {
+			waiting = append(waiting, req)
+			continue
+		}
+
+		s.usedCpus += req.res.Cpu
+		s.usedMemory += req.res.Memory
+		for _, tag := range req.res.Exclusive {
+			s.usedTags[tag]++
+		}
+		close(req.ready)
+	}
+
+	s.waiting = waiting
+}
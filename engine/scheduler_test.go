@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParseResources(t *testing.T) {
+	res, err := parseResources(map[string]interface{}{"timeout": "1m", "cpu": float64(4), "memory": "2G", "exclusive": []interface{}{"docker"}})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, res, &Resources{Cpu: 4, Memory: 2 << 30, Exclusive: []string{"docker"}})
+
+	res, err = parseResources(map[string]interface{}{"exclusive": "docker"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, res, &Resources{Cpu: 1, Exclusive: []string{"docker"}})
+
+	_, err = parseResources(map[string]interface{}{"cpu": "none"})
+	assert.ErrorContains(t, err, "cpu has to be a positive number")
+}
+
+// counter tracks how many steps run at once, and the most it has seen
+type counter struct {
+	mu        sync.Mutex
+	cur, peak int
+}
+
+func (c *counter) inc() func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cur++
+	if c.cur > c.peak {
+		c.peak = c.cur
+	}
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.cur--
+	}
+}
+
+func TestSchedulerLimitsExclusiveTags(t *testing.T) {
+	s := NewScheduler(4, 0, map[string]int{"docker": 2})
+
+	running, docker := &counter{}, &counter{}
+	var wg sync.WaitGroup
+	run := func(res *Resources) {
+		defer wg.Done()
+		release, err := s.Acquire(context.Background(), res, 0)
+		if err != nil {
+			return
+		}
+		defer release()
+
+		defer running.inc()()
+		if len(res.Exclusive) > 0 {
+			defer docker.inc()()
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for i := 0; i < 6; i++ {
+		wg.Add(2)
+		go run(&Resources{Cpu: 1, Exclusive: []string{"docker"}})
+		go run(&Resources{Cpu: 1})
+	}
+	wg.Wait()
+
+	assert.Equal(t, docker.peak, 2)
+	assert.Equal(t, running.peak, 4)
+}
+
+func TestSchedulerClampsOversizedRequests(t *testing.T) {
+	s := NewScheduler(2, 1024, nil)
+
+	release, err := s.Acquire(context.Background(), &Resources{Cpu: 8, Memory: 4096}, 0)
+	assert.NilError(t, err)
+	acquired := make(chan struct{})
+	go func() {
+		if release, err := s.Acquire(context.Background(), &Resources{Cpu: 1}, 0); err == nil {
+			release()
+		}
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("a step ran next to one using the whole host")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	release()
+	<-acquired
+}
+
+func TestSchedulerGrantsByPriority(t *testing.T) {
+	s := NewScheduler(1, 0, nil)
+	release, err := s.Acquire(context.Background(), &Resources{Cpu: 1}, 0)
+	assert.NilError(t, err)
+
+	order := make(chan string, 3)
+	var wg sync.WaitGroup
+	for i, step := range []struct {
+		name     string
+		priority time.Duration
+	}{{"short", time.Second}, {"long", time.Minute}, {"medium", 10 * time.Second}} {
+		wg.Add(1)
+		go func(name string, priority time.Duration) {
+			defer wg.Done()
+			if release, err := s.Acquire(context.Background(), &Resources{Cpu: 1}, priority); err == nil {
+				release()
+			}
+			order <- name
+		}(step.name, step.priority)
+
+		// wait for the request to be queued
+		for {
+			s.mu.Lock()
+			queued := len(s.waiting)
+			s.mu.Unlock()
+			if queued == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	release()
+	wg.Wait()
+	close(order)
+
+	got := []string{}
+	for name := range order {
+		got = append(got, name)
+	}
+	assert.DeepEqual(t, got, []string{"long", "medium", "short"})
+}
+
+func TestSchedulerWithdrawsCancelledRequests(t *testing.T) {
+	s := NewScheduler(1, 0, nil)
+	release, err := s.Acquire(context.Background(), &Resources{Cpu: 1}, 0)
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.Acquire(ctx, &Resources{Cpu: 1}, 0)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, len(s.waiting), 0)
+
+	release()
+	release, err = s.Acquire(context.Background(), &Resources{Cpu: 1}, 0)
+	assert.NilError(t, err)
+	release()
+}
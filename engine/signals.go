@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/zen-io/zen-core/target"
+	"github.com/zen-io/zen-engine/cache"
+)
+
+// defaultGracePeriod is how long interrupted scripts get to exit before they are killed
+const defaultGracePeriod = 10 * time.Second
+
+// handleSignals cancels the run on SIGINT or SIGTERM, so no more steps are started and the steps
+// in flight stop their scripts. A second signal kills the scripts and exits right away.
+// The returned function stops handling.
+func (eng *Engine) handleSignals() func() {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-sigs:
+			eng.Warnln("received %s, stopping the steps in flight", sig)
+			eng.cancelRun()
+		case <-done:
+			return
+		}
+
+		select {
+		case sig := <-sigs:
+			eng.Errorln("received %s again, exiting", sig)
+			eng.groups.kill()
+			eng.Done()
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// discardInterruptedStep removes what an interrupted build left in its build folder, so it is never
+// reused. Outs, archives and metadata are only written after a successful run, so they stay consistent.
+func (eng *Engine) discardInterruptedStep(script string, t *target.Target, ci *cache.CacheItem) {
+	if script != "build" || t.External || ci.BaseBuildCache == "" {
+		return
+	}
+
+	if err := os.RemoveAll(ci.BuildCachePath()); err != nil {
+		t.Warnln("removing interrupted build folder: %s", err)
+	}
+}
+
+// resetBuildFolder discards what a failed build left, and places its srcs again
+func (eng *Engine) resetBuildFolder(script string, t *target.Target, ci *cache.CacheItem) error {
+	if script != "build" || t.External || ci.BaseBuildCache == "" {
+		return nil
+	}
+
+	eng.discardInterruptedStep(script, t, ci)
+	if err := ci.CopySrcsToCache(); err != nil {
+		return fmt.Errorf("resetting build folder: %w", err)
+	}
+
+	return os.MkdirAll(ci.BuildCachePath(), os.ModePerm)
+}
@@ -0,0 +1,284 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	environs "github.com/zen-io/zen-core/environments"
+	"github.com/zen-io/zen-core/target"
+	"github.com/zen-io/zen-engine/cache"
+	"github.com/zen-io/zen-engine/sandbox"
+)
+
+// processGroups are the process groups of the scripts in flight
+type processGroups struct {
+	mu   sync.Mutex
+	pids map[int]bool
+}
+
+func newProcessGroups() *processGroups {
+	return &processGroups{pids: map[int]bool{}}
+}
+
+func (pg *processGroups) add(pid int) {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	pg.pids[pid] = true
+}
+
+func (pg *processGroups) remove(pid int) {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	delete(pg.pids, pid)
+}
+
+// kill sends SIGKILL to every group, without waiting for a grace period
+func (pg *processGroups) kill() {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+
+	for pid := range pg.pids {
+		killProcessGroup(pid)
+	}
+}
+
+// runScript runs the script of a step. Scripts run in the engine process, where the hooks
+// prepared the target for them. Sandboxed builds and scripts that have to be stoppable, like the
+// ones with a timeout, run in a child process instead, in a process group of their own that is
+// stopped when ctx is done: it gets SIGTERM, and SIGKILL after the grace period.
+func (eng *Engine) runScript(ctx context.Context, step, script string, t *target.Target, ci *cache.CacheItem, stoppable bool) error {
+	isolated := script == "build" && eng.sandboxEnabled(t)
+	if isolated && !sandbox.Supported() {
+		return fmt.Errorf("sandboxed builds need linux user namespaces")
+	}
+
+	if !isolated && !stoppable {
+		return t.Scripts[script].Run(t, eng.Ctx)
+	}
+
+	return eng.runScriptInChild(ctx, step, script, t, ci, isolated)
+}
+
+// runScriptInChild runs the script in a child process, started with everything the parent
+// prepared for the step
+func (eng *Engine) runScriptInChild(ctx context.Context, step, script string, t *target.Target, ci *cache.CacheItem, isolated bool) error {
+	stepDir, err := os.MkdirTemp("", "zen-step-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stepDir)
+
+	state, err := json.Marshal(newStepState(t, eng.Ctx))
+	if err != nil {
+		return fmt.Errorf("encoding step: %w", err)
+	}
+
+	spec := &sandbox.Spec{
+		Fqn:  step,
+		Cwd:  t.Cwd,
+		Step: state,
+	}
+
+	if isolated {
+		spec.Isolated = true
+		spec.Workdir = ci.BuildCachePath()
+		spec.ReadOnly = eng.sandboxPaths(t)
+		spec.Tmp = filepath.Join(stepDir, "tmp")
+		spec.Root = filepath.Join(stepDir, "root")
+
+		for _, dir := range []string{spec.Tmp, spec.Root} {
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				return err
+			}
+		}
+	}
+
+	specPath := filepath.Join(stepDir, "spec.json")
+	if err := spec.Write(specPath); err != nil {
+		return fmt.Errorf("writing step spec: %w", err)
+	}
+
+	cmd, err := stepCommand(specPath, isolated)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			t.Infoln("%s", scanner.Text())
+		}
+	}()
+
+	if err := cmd.Start(); err != nil {
+		pw.Close()
+		<-done
+		return fmt.Errorf("starting step process: %w", err)
+	}
+	eng.groups.add(cmd.Process.Pid)
+	defer eng.groups.remove(cmd.Process.Pid)
+
+	// the step process can exit before the scripts it started, so the group is stopped in full
+	// before returning
+	exited, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			stopProcessGroup(cmd.Process.Pid, eng.gracePeriod)
+		case <-exited:
+		}
+	}()
+
+	err = cmd.Wait()
+	close(exited)
+	<-stopped
+	pw.Close()
+	<-done
+
+	if err != nil && isolated {
+		return fmt.Errorf("sandboxed %s: %w (only srcs, tools and toolchains are visible to it)", script, err)
+	} else if err != nil {
+		return fmt.Errorf("%s: %w", script, err)
+	}
+
+	return nil
+}
+
+// stepCommand re-executes the engine to run a single step, pointed to its spec
+func stepCommand(specPath string, isolated bool) (*exec.Cmd, error) {
+	var cmd *exec.Cmd
+	if isolated {
+		var err error
+		if cmd, err = sandbox.Command(specPath, os.Args[1:]...); err != nil {
+			return nil, err
+		}
+	} else {
+		self, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("finding the current executable: %w", err)
+		}
+
+		cmd = exec.Command(self, os.Args[1:]...)
+		cmd.Env = append(os.Environ(), sandbox.EnvVar+"="+specPath)
+	}
+
+	setProcessGroup(cmd)
+	return cmd, nil
+}
+
+// runStepProcess runs in the child started by runScriptInChild. The step was already prepared
+// by the parent, so it only runs the script, in the sandbox when the step is isolated.
+func (eng *Engine) runStepProcess(specPath string) error {
+	spec, err := sandbox.ReadSpec(specPath)
+	if err != nil {
+		return fmt.Errorf("reading step spec: %w", err)
+	}
+
+	fqn, err := target.NewFqnFromStr(spec.Fqn)
+	if err != nil {
+		return err
+	}
+
+	// the scripts are functions of the target, so they come from parsing its package again
+	ts, err := eng.ResolveTarget(fqn)
+	if err != nil {
+		return err
+	}
+	t := ts[0]
+
+	if t.TaskLogger, err = eng.out.CreateTask(spec.Fqn, ""); err != nil {
+		return err
+	}
+	defer t.Done()
+
+	return runStep(spec, t, fqn.Script())
+}
+
+// runStep runs the script of the step, on the target as the parent left it
+func runStep(spec *sandbox.Spec, t *target.Target, script string) error {
+	state := &stepState{}
+	if err := json.Unmarshal(spec.Step, state); err != nil {
+		return fmt.Errorf("decoding step: %w", err)
+	}
+	state.apply(t)
+
+	if spec.Isolated {
+		if err := sandbox.Enter(spec); err != nil {
+			return fmt.Errorf("entering sandbox: %w", err)
+		}
+	}
+
+	return t.Scripts[script].Run(t, state.RunCtx)
+}
+
+// stepState is the target as the engine and the pre hooks prepared it for a script, and the
+// runtime context, so a script run in a child process sees the same as in the engine
+type stepState struct {
+	Srcs         map[string][]string
+	Outs         []string
+	Labels       []string
+	Hashes       []string
+	Tools        map[string]string
+	Environments map[string]*environs.Environment
+	Env          map[string]string
+	PassEnv      []string
+	SecretEnv    []string
+	Local        bool
+	Binary       bool
+	External     bool
+	Clean        bool
+	Cwd          string
+
+	RunCtx *target.RuntimeContext
+}
+
+func newStepState(t *target.Target, runCtx *target.RuntimeContext) *stepState {
+	return &stepState{
+		Srcs:         t.Srcs,
+		Outs:         t.Outs,
+		Labels:       t.Labels,
+		Hashes:       t.Hashes,
+		Tools:        t.Tools,
+		Environments: t.Environments,
+		Env:          t.Env,
+		PassEnv:      t.PassEnv,
+		SecretEnv:    t.SecretEnv,
+		Local:        t.Local,
+		Binary:       t.Binary,
+		External:     t.External,
+		Clean:        t.Clean,
+		Cwd:          t.Cwd,
+		RunCtx:       runCtx,
+	}
+}
+
+func (s *stepState) apply(t *target.Target) {
+	t.Srcs = s.Srcs
+	t.Outs = s.Outs
+	t.Labels = s.Labels
+	t.Hashes = s.Hashes
+	t.Tools = s.Tools
+	t.Environments = s.Environments
+	t.Env = s.Env
+	t.PassEnv = s.PassEnv
+	t.SecretEnv = s.SecretEnv
+	t.Local = s.Local
+	t.Binary = s.Binary
+	t.External = s.External
+	t.Clean = s.Clean
+	t.Cwd = s.Cwd
+}
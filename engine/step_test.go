@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"github.com/zen-io/zen-core/utils"
+	"github.com/zen-io/zen-engine/cache"
+	"github.com/zen-io/zen-engine/config"
+	"github.com/zen-io/zen-engine/sandbox"
+
+	out_mgr "github.com/tiagoposse/go-tasklist-out"
+	"gotest.tools/v3/assert"
+)
+
+// mockStepTargets are the targets the children started by runScript can run
+var mockStepTargets = map[string]func() *zen_targets.Target{
+	"srcs":     mockSrcsTarget,
+	"state":    mockStateTarget,
+	"stubborn": mockStubbornTarget,
+}
+
+// in the child started by runScript, run the step of one of mockStepTargets
+func TestMain(m *testing.M) {
+	if specPath := os.Getenv(sandbox.EnvVar); specPath != "" {
+		spec, err := sandbox.ReadSpec(specPath)
+		if err == nil {
+			var fqn *zen_targets.QualifiedTargetName
+			if fqn, err = zen_targets.NewFqnFromStr(spec.Fqn); err == nil {
+				err = runStep(spec, mockStepTargets[fqn.Name()](), fqn.Script())
+			}
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// mockSrcsTarget writes the srcs it gets, and their content, to an out
+func mockSrcsTarget() *zen_targets.Target {
+	t := zen_targets.NewTarget("srcs", zen_targets.WithSrcs(map[string][]string{"_srcs": {"input.txt"}}), zen_targets.WithOuts([]string{"out.txt"}))
+	t.SetFqn("project", "pkg")
+	t.Scripts = map[string]*zen_targets.TargetScript{
+		"build": {Run: func(t *zen_targets.Target, _ *zen_targets.RuntimeContext) error {
+			lines := []string{}
+			for _, src := range t.Srcs["_srcs"] {
+				content, err := os.ReadFile(src)
+				if err != nil {
+					return err
+				}
+				lines = append(lines, fmt.Sprintf("%s %s", src, content))
+			}
+			sort.Strings(lines)
+
+			return os.WriteFile(filepath.Join(t.Cwd, "out.txt"), []byte(strings.Join(lines, "\n")), 0644)
+		}},
+	}
+
+	return t
+}
+
+// mockStateTarget writes what the engine and the hooks prepared for it to an out
+func mockStateTarget() *zen_targets.Target {
+	t := zen_targets.NewTarget("state")
+	t.SetFqn("project", "pkg")
+	t.Scripts = map[string]*zen_targets.TargetScript{
+		"build": {Run: func(t *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+			state := fmt.Sprintf("hook=%s clean=%t env=%s", t.Env["FROM_HOOK"], t.Clean, runCtx.Env)
+			return os.WriteFile(filepath.Join(t.Cwd, "state.txt"), []byte(state), 0644)
+		}},
+	}
+
+	return t
+}
+
+// mockStubbornTarget runs a script that ignores SIGTERM, and records its pid
+func mockStubbornTarget() *zen_targets.Target {
+	t := zen_targets.NewTarget("stubborn")
+	t.SetFqn("project", "pkg")
+	t.Scripts = map[string]*zen_targets.TargetScript{
+		"build": {Run: func(t *zen_targets.Target, _ *zen_targets.RuntimeContext) error {
+			return exec.Command("sh", "-c", fmt.Sprintf("trap '' TERM; echo $$ > %s/script.pid; sleep 30", t.Cwd)).Run()
+		}},
+	}
+
+	return t
+}
+
+func mockStep(t *testing.T, target *zen_targets.Target, sandboxed bool) (*Engine, *cache.CacheItem) {
+	root := t.TempDir()
+	target.SetOriginalPath(filepath.Join(root, "pkg"))
+	assert.NilError(t, os.MkdirAll(target.Path(), os.ModePerm))
+	for _, src := range target.Srcs["_srcs"] {
+		assert.NilError(t, os.WriteFile(filepath.Join(target.Path(), src), []byte("input"), 0644))
+	}
+
+	ui, err := out_mgr.NewOutputManager(out_mgr.WithOut(io.Discard), out_mgr.WithRawOutput())
+	assert.NilError(t, err)
+	target.TaskLogger, err = ui.CreateTask(target.Name, "")
+	assert.NilError(t, err)
+
+	cm, err := cache.NewCacheManager(&cache.CacheConfig{
+		Tmp:       utils.StringPtr(filepath.Join(root, "tmp")),
+		Metadata:  utils.StringPtr(filepath.Join(root, "metadata")),
+		Out:       utils.StringPtr(filepath.Join(root, "out")),
+		Artifacts: utils.StringPtr(filepath.Join(root, "artifacts")),
+	})
+	assert.NilError(t, err)
+	ci, err := cm.LoadTargetCache(target, nil)
+	assert.NilError(t, err)
+	assert.NilError(t, ci.CopySrcsToCache())
+	assert.NilError(t, os.MkdirAll(ci.BuildCachePath(), os.ModePerm))
+	target.Cwd = ci.BuildCachePath()
+
+	eng := &Engine{
+		sandbox:     sandboxed,
+		gracePeriod: 200 * time.Millisecond,
+		groups:      newProcessGroups(),
+		Projects: map[string]*config.Project{
+			"project": {Config: &config.ProjectConfig{Build: &config.ProjectBuildConfig{}}},
+		},
+	}
+
+	return eng, ci
+}
+
+func TestRunScriptSandboxed(t *testing.T) {
+	if !sandbox.Supported() {
+		t.Skip("user namespaces are not available")
+	}
+
+	target := mockSrcsTarget()
+	eng, ci := mockStep(t, target, true)
+	assert.NilError(t, eng.runScript(context.Background(), "//project/pkg:srcs:build", "build", target, ci, false))
+
+	// the script got the srcs expanded into the build folder
+	out, err := os.ReadFile(filepath.Join(ci.BuildCachePath(), "out.txt"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(out), filepath.Join(ci.BuildCachePath(), "input.txt")+" input")
+}
+
+func TestResetBuildFolder(t *testing.T) {
+	target := mockSrcsTarget()
+	eng, ci := mockStep(t, target, false)
+	assert.NilError(t, os.WriteFile(filepath.Join(ci.BuildCachePath(), "partial.o"), []byte("partial"), 0644))
+
+	assert.NilError(t, eng.resetBuildFolder("build", target, ci))
+
+	_, err := os.Stat(filepath.Join(ci.BuildCachePath(), "partial.o"))
+	assert.Assert(t, os.IsNotExist(err))
+	content, err := os.ReadFile(filepath.Join(ci.BuildCachePath(), "input.txt"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "input")
+}
+
+func TestRunScriptKeepsThePreparedTarget(t *testing.T) {
+	for name, stoppable := range map[string]bool{"in process": false, "in a child": true} {
+		t.Run(name, func(t *testing.T) {
+			target := mockStateTarget()
+			eng, ci := mockStep(t, target, false)
+			eng.Ctx = &zen_targets.RuntimeContext{Env: "prod"}
+
+			// as left by the pre hooks and --clean
+			target.Env = map[string]string{"FROM_HOOK": "yes"}
+			target.Clean = true
+
+			assert.NilError(t, eng.runScript(context.Background(), "//project/pkg:state:build", "build", target, ci, stoppable))
+
+			state, err := os.ReadFile(filepath.Join(ci.BuildCachePath(), "state.txt"))
+			assert.NilError(t, err)
+			assert.Equal(t, string(state), "hook=yes clean=true env=prod")
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type stepStatus int
+
+const (
+	stepSucceeded stepStatus = iota
+	stepFailed
+	stepSkipped
+	stepCancelled
+)
+
+type stepOutcome struct {
+	status    stepStatus
+	err       error
+	blockedBy string
+}
+
+type stepAttempts struct {
+	attempts int
+	timeouts int
+}
+
+// runSummary collects the outcome of every step that was scheduled in the run, and the steps
+// that needed retries or timed out
+type runSummary struct {
+	mu       sync.Mutex
+	outcomes map[string]*stepOutcome
+	attempts map[string]*stepAttempts
+}
+
+func newRunSummary() *runSummary {
+	return &runSummary{
+		outcomes: map[string]*stepOutcome{},
+		attempts: map[string]*stepAttempts{},
+	}
+}
+
+func (rs *runSummary) record(step string, outcome *stepOutcome) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.outcomes[step] = outcome
+}
+
+// recordAttempts keeps how many times the script of a step ran, when it ran more than once or timed out
+func (rs *runSummary) recordAttempts(step string, attempts, timeouts int) {
+	if attempts <= 1 && timeouts == 0 {
+		return
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.attempts[step] = &stepAttempts{attempts: attempts, timeouts: timeouts}
+}
+
+// retried returns the steps that ran more than once or timed out, sorted
+func (rs *runSummary) retried() []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	steps := []string{}
+	for step := range rs.attempts {
+		steps = append(steps, step)
+	}
+	sort.Strings(steps)
+
+	return steps
+}
+
+func (rs *runSummary) attemptsFor(step string) *stepAttempts {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.attempts[step]
+}
+
+// blockedBy returns the first dep that failed or was skipped, if any
+func (rs *runSummary) blockedBy(deps []string) string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for _, dep := range deps {
+		if o, ok := rs.outcomes[dep]; ok && (o.status == stepFailed || o.status == stepSkipped) {
+			return dep
+		}
+	}
+
+	return ""
+}
+
+// steps returns the steps with the given status, sorted
+func (rs *runSummary) steps(status stepStatus) []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	steps := []string{}
+	for step, o := range rs.outcomes {
+		if o.status == status {
+			steps = append(steps, step)
+		}
+	}
+	sort.Strings(steps)
+
+	return steps
+}
+
+func (rs *runSummary) outcome(step string) *stepOutcome {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.outcomes[step]
+}
+
+// runVertex runs a step of the graph once its resources are granted, and records its outcome.
+// With keep going, failures are kept from the DAG so the independent branches still run, and
+// the steps that depend on a failure are skipped. With fail fast, the first failure cancels
+// the run, and the steps in flight stop their scripts.
+func (eng *Engine) runVertex(ctx context.Context, step string, res *Resources) error {
+	if err := ctx.Err(); err != nil {
+		eng.summary.record(step, &stepOutcome{status: stepCancelled, err: err})
+		return err
+	}
+
+	if eng.keepGoing {
+		if blocker := eng.summary.blockedBy(eng.deps[step]); blocker != "" {
+			eng.summary.record(step, &stepOutcome{status: stepSkipped, blockedBy: blocker})
+			return nil
+		}
+	}
+
+	release, err := eng.scheduler.Acquire(ctx, res, eng.priorities[step])
+	if err != nil {
+		eng.summary.record(step, &stepOutcome{status: stepCancelled, err: err})
+		return err
+	}
+	defer release()
+
+	start := time.Now()
+	err = eng._run_step(ctx, step)
+	eng.stepTimes.record(step, time.Since(start))
+
+	if err == nil {
+		eng.summary.record(step, &stepOutcome{status: stepSucceeded})
+		return nil
+	}
+
+	// steps terminated because of another failure did not fail on their own
+	if ctx.Err() != nil {
+		eng.summary.record(step, &stepOutcome{status: stepCancelled, err: err})
+		return err
+	}
+
+	eng.summary.record(step, &stepOutcome{status: stepFailed, err: err})
+	if eng.failFast {
+		eng.cancelRun()
+	}
+
+	if eng.keepGoing {
+		return nil
+	}
+
+	return err
+}
+
+// reportSummary writes the failed, skipped, cancelled and retried steps of the run, and how many succeeded
+func (eng *Engine) reportSummary() {
+	failed := eng.summary.steps(stepFailed)
+	skipped := eng.summary.steps(stepSkipped)
+	cancelled := eng.summary.steps(stepCancelled)
+	succeeded := eng.summary.steps(stepSucceeded)
+
+	for _, step := range failed {
+		eng.Errorln("failed %s: %s", step, eng.summary.outcome(step).err)
+	}
+	for _, step := range skipped {
+		eng.Warnln("skipped %s: %s did not succeed", step, eng.summary.outcome(step).blockedBy)
+	}
+	for _, step := range cancelled {
+		eng.Warnln("cancelled %s", step)
+	}
+
+	retried := eng.summary.retried()
+	for _, step := range retried {
+		a := eng.summary.attemptsFor(step)
+		eng.Warnln("retried %s: %d attempts, %d timed out", step, a.attempts, a.timeouts)
+	}
+
+	eng.Infoln("%d succeeded, %d failed, %d skipped, %d cancelled, %d retried", len(succeeded), len(failed), len(skipped), len(cancelled), len(retried))
+}
@@ -0,0 +1,24 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestRunSummarySkipsDependentsOfFailures(t *testing.T) {
+	rs := newRunSummary()
+	rs.record("//proj/pkg:lib:build", &stepOutcome{status: stepFailed, err: errors.New("boom")})
+	rs.record("//proj/pkg:other:build", &stepOutcome{status: stepSucceeded})
+
+	assert.Equal(t, rs.blockedBy([]string{"//proj/pkg:other:build"}), "")
+	assert.Equal(t, rs.blockedBy([]string{"//proj/pkg:other:build", "//proj/pkg:lib:build"}), "//proj/pkg:lib:build")
+
+	// skips propagate to the dependents of skipped steps
+	rs.record("//proj/pkg:app:build", &stepOutcome{status: stepSkipped, blockedBy: "//proj/pkg:lib:build"})
+	assert.Equal(t, rs.blockedBy([]string{"//proj/pkg:app:build"}), "//proj/pkg:app:build")
+
+	assert.DeepEqual(t, rs.steps(stepFailed), []string{"//proj/pkg:lib:build"})
+	assert.DeepEqual(t, rs.steps(stepSucceeded), []string{"//proj/pkg:other:build"})
+}
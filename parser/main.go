@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 
 	zen_targets "github.com/zen-io/zen-core/target"
@@ -18,11 +20,51 @@ import (
 	tf "github.com/zen-io/zen-target-terraform"
 
 	"github.com/mitchellh/mapstructure"
+	atomics "github.com/tiagoposse/go-sync-types"
 )
 
 type PackageParser struct {
-	parsers  map[string]zen_targets.TargetCreatorMap // types per project
-	projects map[string]*config.ProjectConfig
+	parsers     map[string]zen_targets.TargetCreatorMap // types per project
+	projects    map[string]*config.ProjectConfig
+	definitions *atomics.Map[string, *BlockDefinition] // per target qn
+}
+
+// RuntimeAttributes tell the engine how to run the scripts of a block, not what they build: their
+// timeout, retries and the host resources they need. They are left out of the block digest, so
+// tuning them does not rebuild the targets, and are not passed to the target types.
+var RuntimeAttributes = []string{"timeout", "retries", "retry_backoff", "cpu", "memory", "exclusive"}
+
+// BlockDefinition identifies the package block a target was declared in
+type BlockDefinition struct {
+	Type   string
+	Digest string
+	// Runtime holds the runtime attributes set in the block
+	Runtime map[string]interface{}
+}
+
+// newBlockDefinition splits the runtime attributes from the block, and digests the rest
+func newBlockDefinition(blockType string, block map[string]interface{}) (*BlockDefinition, map[string]interface{}, error) {
+	def := &BlockDefinition{Type: blockType, Runtime: map[string]interface{}{}}
+
+	rest := make(map[string]interface{}, len(block))
+	for k, v := range block {
+		rest[k] = v
+	}
+	for _, attr := range RuntimeAttributes {
+		if v, ok := rest[attr]; ok {
+			def.Runtime[attr] = v
+			delete(rest, attr)
+		}
+	}
+
+	// json sorts map keys, so the digest is stable across parses
+	data, err := json.Marshal(rest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding %s block: %w", blockType, err)
+	}
+	def.Digest = fmt.Sprintf("%x", sha256.Sum256(data))
+
+	return def, rest, nil
 }
 
 func NewPackageParser() (*PackageParser, error) {
@@ -30,8 +72,9 @@ func NewPackageParser() (*PackageParser, error) {
 	// plugins := []*config.ProjectPluginConfig{}
 
 	return &PackageParser{
-		parsers:  make(map[string]zen_targets.TargetCreatorMap),
-		projects: make(map[string]*config.ProjectConfig),
+		parsers:     make(map[string]zen_targets.TargetCreatorMap),
+		projects:    make(map[string]*config.ProjectConfig),
+		definitions: atomics.NewMap[string, *BlockDefinition](),
 	}, nil
 }
 
@@ -59,6 +102,17 @@ func (pp *PackageParser) Initialize(projs map[string]*config.ProjectConfig) {
 	pp.projects = projs
 }
 
+// RegisterTargetTypes makes more block types known to a project, on top of the built in ones
+func (pp *PackageParser) RegisterTargetTypes(project string, types zen_targets.TargetCreatorMap) {
+	if pp.parsers[project] == nil {
+		pp.parsers[project] = make(zen_targets.TargetCreatorMap)
+	}
+
+	for stepType, itype := range types {
+		pp.parsers[project][stepType] = itype
+	}
+}
+
 func (pp *PackageParser) KnownTypes(project string) zen_targets.TargetCreatorMap {
 	return pp.parsers[project]
 }
@@ -84,8 +138,13 @@ func (pp *PackageParser) ParsePackageTargets(project, pkg string) ([]*zen_target
 		}
 
 		for _, block := range blocks {
+			def, targetBlock, err := newBlockDefinition(blockType, block)
+			if err != nil {
+				return nil, err
+			}
+
 			ifaceBlock := iface
-			if err := DecodePackage(block, &ifaceBlock); err != nil {
+			if err := DecodePackage(targetBlock, &ifaceBlock); err != nil {
 				return nil, err
 			}
 
@@ -105,6 +164,7 @@ func (pp *PackageParser) ParsePackageTargets(project, pkg string) ([]*zen_target
 
 			for _, bt := range blockTargets {
 				bt.SetFqn(project, pkg)
+				pp.definitions.Put(bt.Qn(), def)
 				targets = append(targets, bt)
 			}
 		}
@@ -113,6 +173,11 @@ func (pp *PackageParser) ParsePackageTargets(project, pkg string) ([]*zen_target
 	return targets, nil
 }
 
+// TargetDefinition returns the block a parsed target was declared in
+func (pp *PackageParser) TargetDefinition(qn string) (*BlockDefinition, bool) {
+	return pp.definitions.Get(qn)
+}
+
 func DecodePackage(in interface{}, out interface{}) error {
 	config := &mapstructure.DecoderConfig{
 		Metadata:    nil,
@@ -0,0 +1,58 @@
+// Package sandbox runs build steps in a private view of the filesystem, where only the build
+// folder, the declared tools and toolchains, the system folders and a tmp dir exist.
+package sandbox
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// EnvVar points a sandboxed child process to its Spec
+const EnvVar = "ZEN_SANDBOX_SPEC"
+
+// SystemPaths are always mounted read only, so the usual binaries and libraries keep working
+var SystemPaths = []string{"/bin", "/sbin", "/usr", "/lib", "/lib32", "/lib64", "/etc"}
+
+// Spec describes the step to run and, when it is isolated, everything it can see
+type Spec struct {
+	// Fqn is the step to run, and Step what the parent prepared for it, encoded by the engine
+	Fqn  string          `json:"fqn"`
+	Step json.RawMessage `json:"step"`
+	// Cwd is where the step runs
+	Cwd string `json:"cwd"`
+
+	// Isolated steps enter the sandbox, the others only run in a process of their own
+	Isolated bool `json:"isolated"`
+
+	// Workdir is mounted read write, at the same path
+	Workdir string `json:"workdir"`
+	// ReadOnly paths are mounted at the same path. Missing paths are skipped.
+	ReadOnly []string `json:"read_only"`
+	// Tmp is mounted at /tmp
+	Tmp string `json:"tmp"`
+	// Root is an empty folder the new root is mounted on, removed by the parent with the step
+	Root string `json:"root"`
+}
+
+func (s *Spec) Write(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+func ReadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &Spec{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
@@ -0,0 +1,157 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+func Supported() bool {
+	_, err := os.Stat("/proc/self/ns/user")
+	return err == nil
+}
+
+// Command re-executes the current binary in new user and mount namespaces, with the spec
+// path in EnvVar. The child maps the current user, so files it writes keep their owner.
+func Command(specPath string, args ...string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("finding the current executable: %w", err)
+	}
+
+	cmd := exec.Command(self, args...)
+	cmd.Env = append(os.Environ(), EnvVar+"="+specPath)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1},
+		},
+		GidMappingsEnableSetgroups: false,
+	}
+
+	return cmd, nil
+}
+
+// Enter replaces the root of the current mount namespace with one holding only what the spec
+// declares, and moves into the spec cwd. It must run in a process started by Command.
+func Enter(spec *Spec) error {
+	// keep the mounts below from propagating back to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("making mounts private: %w", err)
+	}
+
+	// the tmpfs only exists in this namespace, so the host only sees an empty folder
+	root := spec.Root
+	if root == "" {
+		return fmt.Errorf("the sandbox needs a root folder")
+	}
+
+	if err := syscall.Mount("tmpfs", root, "tmpfs", 0, "mode=0755"); err != nil {
+		return fmt.Errorf("mounting sandbox root: %w", err)
+	}
+
+	// tmp goes first, so declared paths under /tmp are not hidden by it
+	if spec.Tmp != "" {
+		if err := bindMount(spec.Tmp, filepath.Join(root, "tmp"), false); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range append(append([]string{}, SystemPaths...), spec.ReadOnly...) {
+		if err := bindMount(p, filepath.Join(root, p), true); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range []string{"/dev", "/proc"} {
+		if err := bindMount(p, filepath.Join(root, p), false); err != nil {
+			return err
+		}
+	}
+
+	if err := bindMount(spec.Workdir, filepath.Join(root, spec.Workdir), false); err != nil {
+		return err
+	}
+
+	if err := pivotRoot(root); err != nil {
+		return err
+	}
+
+	if err := os.Chdir(spec.Cwd); err != nil {
+		return fmt.Errorf("entering %s: %w", spec.Cwd, err)
+	}
+
+	return nil
+}
+
+func bindMount(src, dest string, readOnly bool) error {
+	info, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// the mount point has to exist, and match the type of the source
+	if info.IsDir() {
+		err = os.MkdirAll(dest, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(dest), 0755); err == nil {
+		err = os.WriteFile(dest, nil, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("creating mount point for %s: %w", src, err)
+	}
+
+	if err := syscall.Mount(src, dest, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("mounting %s: %w", src, err)
+	}
+
+	if !readOnly {
+		return nil
+	}
+
+	// a remount can only add flags to the ones locked by the host
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(src, &st); err != nil {
+		return err
+	}
+
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	for _, f := range []uintptr{syscall.MS_NOSUID, syscall.MS_NODEV, syscall.MS_NOEXEC, syscall.MS_NOATIME, syscall.MS_NODIRATIME, syscall.MS_RELATIME} {
+		if uintptr(st.Flags)&f != 0 {
+			flags |= f
+		}
+	}
+
+	if err := syscall.Mount("", dest, "", flags, ""); err != nil {
+		return fmt.Errorf("making %s read only: %w", src, err)
+	}
+
+	return nil
+}
+
+func pivotRoot(root string) error {
+	oldRoot := filepath.Join(root, ".oldroot")
+	if err := os.MkdirAll(oldRoot, 0700); err != nil {
+		return err
+	}
+
+	if err := syscall.PivotRoot(root, oldRoot); err != nil {
+		return fmt.Errorf("pivoting root: %w", err)
+	}
+
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+
+	if err := syscall.Unmount("/.oldroot", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("detaching host root: %w", err)
+	}
+
+	return os.Remove("/.oldroot")
+}
@@ -0,0 +1,92 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// in the child, enter the sandbox and report which files are visible
+func TestMain(m *testing.M) {
+	if specPath := os.Getenv(EnvVar); specPath != "" {
+		spec, err := ReadSpec(specPath)
+		if err == nil {
+			err = Enter(spec)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+
+		// the engine encodes its step, here it is the paths to look for
+		paths := []string{}
+		if err := json.Unmarshal(spec.Step, &paths); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		for _, p := range paths {
+			if _, err := os.Stat(p); err == nil {
+				fmt.Println("visible", p)
+			}
+		}
+		if err := os.WriteFile("built", []byte("ok"), 0644); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestSandboxOnlyExposesDeclaredPaths(t *testing.T) {
+	if !Supported() {
+		t.Skip("user namespaces are not available")
+	}
+
+	repo := t.TempDir()
+	workdir := filepath.Join(repo, ".zen", "tmp", "pkg", "target")
+	tool := filepath.Join(repo, "tools", "tool")
+	secret := filepath.Join(repo, "undeclared")
+	for _, f := range []string{filepath.Join(workdir, "src"), tool, secret} {
+		assert.NilError(t, os.MkdirAll(filepath.Dir(f), os.ModePerm))
+		assert.NilError(t, os.WriteFile(f, nil, 0644))
+	}
+
+	paths, err := json.Marshal([]string{filepath.Join(workdir, "src"), tool, secret})
+	assert.NilError(t, err)
+
+	spec := &Spec{
+		Step:     paths,
+		Cwd:      workdir,
+		Workdir:  workdir,
+		ReadOnly: []string{tool},
+		Tmp:      t.TempDir(),
+		Root:     t.TempDir(),
+	}
+	specPath := filepath.Join(t.TempDir(), "spec.json")
+	assert.NilError(t, spec.Write(specPath))
+
+	cmd, err := Command(specPath)
+	assert.NilError(t, err)
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) == 0 {
+		t.Skipf("cannot create namespaces: %s", err)
+	}
+	assert.NilError(t, err, string(out))
+
+	assert.Equal(t, string(out), fmt.Sprintf("visible %s\nvisible %s\n", filepath.Join(workdir, "src"), tool))
+
+	built, err := os.ReadFile(filepath.Join(workdir, "built"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(built), "ok")
+
+	// the mounts were private to the child
+	entries, err := os.ReadDir(spec.Root)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 0)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errUnsupported = errors.New("sandboxing is only supported on linux")
+
+func Supported() bool {
+	return false
+}
+
+func Command(specPath string, args ...string) (*exec.Cmd, error) {
+	return nil, errUnsupported
+}
+
+func Enter(spec *Spec) error {
+	return errUnsupported
+}